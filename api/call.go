@@ -4,18 +4,22 @@ import (
 	"mkozhukh/chat/data"
 	"mkozhukh/chat/service"
 	"time"
+
+	remote "github.com/mkozhukh/go-remote"
 )
 
 type Call struct {
-	ID          int        `json:"id"`
-	Status      int        `json:"status"`
-	InitiatorID int        `json:"initiator"`
-	ChatID      int        `json:"chat"`
-	Start       *time.Time `json:"start"`
-	IsGroupCall bool       `json:"group"`
-	Name        string     `json:"name"`
-	Avatar      string     `json:"avatar"`
-	Users       []int      `json:"users"`
+	ID          int                        `json:"id"`
+	Status      int                        `json:"status"`
+	InitiatorID int                        `json:"initiator"`
+	ChatID      int                        `json:"chat"`
+	Start       *time.Time                 `json:"start"`
+	IsGroupCall bool                       `json:"group"`
+	Name        string                     `json:"name"`
+	Avatar      string                     `json:"avatar"`
+	Users       []int                      `json:"users"`
+	Media       []service.ParticipantMedia `json:"media"`
+	Guests      []service.CallGuest        `json:"guests"`
 }
 
 type CallsAPI struct {
@@ -23,7 +27,12 @@ type CallsAPI struct {
 	sAll *service.ServiceAll
 }
 
-func (d *CallsAPI) Start(targetUserId int, targetChatId int, ctx *service.CallContext) (*Call, error) {
+func (d *CallsAPI) Start(targetUserId int, targetChatId int, ctx *service.CallContext, ip ClientIP) (*Call, error) {
+	err := d.sAll.Limits.Take("call.Start", ctx.UserID, ctx.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
 	callService, err := service.CallProvider.GetService(targetUserId == 0)
 	if err != nil {
 		return nil, err
@@ -34,6 +43,19 @@ func (d *CallsAPI) Start(targetUserId int, targetChatId int, ctx *service.CallCo
 		return nil, err
 	}
 
+	action := data.AuditCallJoin
+	if call.InitiatorID == ctx.UserID && call.Status == data.CallStatusInitiated {
+		action = data.AuditCallStart
+	}
+	d.db.Audit.Add(data.AuditRecord{
+		Action:   action,
+		ActorID:  ctx.UserID,
+		DeviceID: ctx.DeviceID,
+		IP:       string(ip),
+		ChatID:   call.ChatID,
+		TargetID: call.ID,
+	}, map[string]interface{}{"group": call.IsGroupCall})
+
 	return &Call{
 		ID:          call.ID,
 		Status:      call.Status,
@@ -41,6 +63,8 @@ func (d *CallsAPI) Start(targetUserId int, targetChatId int, ctx *service.CallCo
 		InitiatorID: call.InitiatorID,
 		IsGroupCall: call.IsGroupCall,
 		Users:       call.GetUsersIDs(false),
+		Media:       service.CallMedia(call),
+		Guests:      service.CallGuests(call),
 	}, nil
 }
 
@@ -66,6 +90,103 @@ func (d *CallsAPI) SetStatus(id, status int, ctx *service.CallContext) (int, err
 	return call.Status, err
 }
 
+// Mute mutes or unmutes audio or video of the participant, kind is audio or video
+func (d *CallsAPI) Mute(id, userId int, kind string, muted bool, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.GroupCalls.Mute(ctx, &call, userId, kind, muted)
+}
+
+// Remove disconnects the participant from the group call
+func (d *CallsAPI) Remove(id, userId int, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.GroupCalls.Remove(ctx, &call, userId)
+}
+
+// Lock forbids new participants to join the group call
+func (d *CallsAPI) Lock(id int, locked bool, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.GroupCalls.Lock(ctx, &call, locked)
+}
+
+// End ends the group call for all participants
+func (d *CallsAPI) End(id int, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.GroupCalls.End(ctx, &call)
+}
+
+// SetMedia updates the media state of the user in the call, kind is audio, video, screen or hand
+func (d *CallsAPI) SetMedia(id int, kind string, value bool, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.Calls.SetMedia(ctx, &call, kind, value)
+}
+
+// Hold puts the call on hold, or resumes it and holds other calls of the user
+func (d *CallsAPI) Hold(id int, hold bool, ctx *service.CallContext) error {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.Calls.Hold(ctx, &call, hold)
+}
+
+// AddUser moves the direct call to the group call with the third participant,
+// the direct chat stays as is and the new group chat is created
+func (d *CallsAPI) AddUser(id, userId int, ctx *service.CallContext, events *remote.Hub) (*Call, error) {
+	call, err := d.db.Calls.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []int{userId}
+	for _, u := range call.Users {
+		if u.UserID != ctx.UserID && u.UserID != userId {
+			users = append(users, u.UserID)
+		}
+	}
+
+	chats := ChatsAPI{d.db, d.sAll}
+	oldUsers := d.db.UsersCache.GetUsers(call.ChatID)
+	next, err := d.sAll.PersonalCalls.Transfer(ctx, &call, users, func(chatId int) {
+		chats.getChatInfo(chatId, ctx.UserID, events, oldUsers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Call{
+		ID:          next.ID,
+		Status:      next.Status,
+		Start:       next.Start,
+		InitiatorID: next.InitiatorID,
+		ChatID:      next.ChatID,
+		IsGroupCall: next.IsGroupCall,
+		Users:       next.GetUsersIDs(false),
+		Media:       service.CallMedia(next),
+		Guests:      service.CallGuests(next),
+	}, nil
+}
+
 func (d *CallsAPI) SetUserStatus(id, status int, ctx *service.CallContext) error {
 	call, err := d.db.Calls.Get(id)
 	if err != nil {
@@ -92,6 +213,11 @@ func (d *CallsAPI) SetUserStatus(id, status int, ctx *service.CallContext) error
 }
 
 func (d *CallsAPI) Signal(signalType, msg string, ctx *service.CallContext) error {
+	err := d.sAll.Limits.Take("call.Signal", ctx.UserID, ctx.DeviceID)
+	if err != nil {
+		return err
+	}
+
 	call, err := d.db.Calls.GetByDevice(ctx.DeviceID)
 	if err != nil {
 		return err
@@ -100,6 +226,14 @@ func (d *CallsAPI) Signal(signalType, msg string, ctx *service.CallContext) erro
 		return data.ErrAccessDenied
 	}
 
+	if call.IsGroupCall {
+		// media of group calls goes through the built-in SFU
+		if d.sAll.SFU == nil {
+			return data.ErrFeatureDisabled
+		}
+		return d.sAll.SFU.Signal(ctx, &call, signalType, msg)
+	}
+
 	i := 0
 	if call.Users[0].DeviceID == ctx.DeviceID {
 		i = 1
@@ -112,6 +246,92 @@ func (d *CallsAPI) Signal(signalType, msg string, ctx *service.CallContext) erro
 	return nil
 }
 
+// ReportStats stores WebRTC stats of the client: rtt and jitter in ms, loss in percents, bitrate in kbit/s
+func (d *CallsAPI) ReportStats(callId int, stats service.CallStatsReport, ctx *service.CallContext) error {
+	err := d.sAll.Limits.Take("call.ReportStats", ctx.UserID, ctx.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	return d.sAll.CallStats.Report(ctx, callId, stats)
+}
+
+// ICEServers returns STUN and TURN servers for peer connections, TURN credentials are valid for turn.ttl seconds
+func (d *CallsAPI) ICEServers(userId UserID) []service.ICEServer {
+	return d.sAll.ICE.Servers(int(userId))
+}
+
+func (d *CallsAPI) StartRecording(callId int, ctx *service.CallContext) (*data.Recording, error) {
+	return d.sAll.Recordings.Start(ctx, callId)
+}
+
+func (d *CallsAPI) StopRecording(callId int, ctx *service.CallContext) error {
+	return d.sAll.Recordings.Stop(ctx, callId)
+}
+
+// ScheduleMeeting adds the meeting to the chat, duration is in minutes
+func (d *CallsAPI) ScheduleMeeting(chatId int, title string, start time.Time, duration int, userId UserID) (*data.Meeting, error) {
+	if !d.db.UsersCache.HasChat(int(userId), chatId) {
+		return nil, data.ErrAccessDenied
+	}
+
+	return d.sAll.Meetings.Schedule(int(userId), chatId, title, start, duration)
+}
+
+func (d *CallsAPI) CancelMeeting(id int, userId UserID) error {
+	return d.sAll.Meetings.Cancel(int(userId), id)
+}
+
+// Meetings returns upcoming meetings of the chat and the ones in progress
+func (d *CallsAPI) Meetings(chatId int, userId UserID) ([]data.Meeting, error) {
+	if !d.db.UsersCache.HasChat(int(userId), chatId) {
+		return nil, data.ErrAccessDenied
+	}
+
+	return d.db.Meetings.GetUpcoming(chatId, time.Now())
+}
+
+// JoinMeeting starts the call of the meeting or joins the existing one
+func (d *CallsAPI) JoinMeeting(id int, ctx *service.CallContext) (*Call, error) {
+	m, err := d.db.Meetings.GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+	if !d.db.UsersCache.HasChat(ctx.UserID, m.ChatID) {
+		return nil, data.ErrAccessDenied
+	}
+
+	call, err := d.sAll.Meetings.Join(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Call{
+		ID:          call.ID,
+		Status:      call.Status,
+		Start:       call.Start,
+		InitiatorID: call.InitiatorID,
+		IsGroupCall: call.IsGroupCall,
+		Users:       call.GetUsersIDs(false),
+		Media:       service.CallMedia(call),
+		Guests:      service.CallGuests(call),
+	}, nil
+}
+
+// GuestLink returns the signed link, which allows people without account to join the group call
+func (d *CallsAPI) GuestLink(callId int, ctx *service.CallContext) (string, error) {
+	return d.sAll.Guests.Link(ctx, callId)
+}
+
 func (d *CallsAPI) JoinToken(callId int, ctx *service.CallContext) (string, error) {
 	return d.sAll.Calls.CreateJoinToken(ctx, callId)
 }
+
+// History returns a page of calls of the chat, or of all user's chats if chatId is 0
+func (d *CallsAPI) History(chatId, cursor int, userId UserID) (*data.CallsPage, error) {
+	if chatId != 0 && !d.db.UsersCache.HasChat(int(userId), chatId) {
+		return nil, data.ErrAccessDenied
+	}
+
+	return d.db.Calls.GetHistory(chatId, int(userId), cursor)
+}
@@ -20,7 +20,12 @@ type ChatEvent struct {
 	Data   *data.UserChatDetails `json:"data"`
 }
 
-func (d *ChatsAPI) AddDirect(targetUserId int, userId UserID, events *remote.Hub) (*data.UserChatDetails, error) {
+func (d *ChatsAPI) AddDirect(targetUserId int, userId UserID, deviceId DeviceID, ip ClientIP, events *remote.Hub) (*data.UserChatDetails, error) {
+	err := d.sAll.Limits.Take("chat.AddDirect", int(userId), 0)
+	if err != nil {
+		return nil, err
+	}
+
 	chatId, err := d.db.Chats.AddDirect(targetUserId, int(userId))
 	if err != nil {
 		return nil, err
@@ -30,6 +35,14 @@ func (d *ChatsAPI) AddDirect(targetUserId int, userId UserID, events *remote.Hub
 	if err != nil {
 		return nil, err
 	}
+	d.db.Audit.Add(data.AuditRecord{
+		Action:   data.AuditChatAdd,
+		ActorID:  int(userId),
+		DeviceID: int(deviceId),
+		IP:       string(ip),
+		ChatID:   chatId,
+		TargetID: targetUserId,
+	}, nil)
 
 	// message sent for other user, so change DirectID accordingly
 	messageInfo := *info
@@ -39,12 +52,17 @@ func (d *ChatsAPI) AddDirect(targetUserId int, userId UserID, events *remote.Hub
 	return info, nil
 }
 
-func (d *ChatsAPI) AddGroup(name, avatar string, users []int, userId UserID, events *remote.Hub) (*data.UserChatDetails, error) {
+func (d *ChatsAPI) AddGroup(name, avatar string, users []int, userId UserID, deviceId DeviceID, ip ClientIP, events *remote.Hub) (*data.UserChatDetails, error) {
+	err := d.sAll.Limits.Take("chat.AddGroup", int(userId), 0)
+	if err != nil {
+		return nil, err
+	}
+
 	// sanitize input
 	name = data.SafeHTML(name)
 	avatar = data.SafeUrl(avatar)
 
-	chatId, err := d.db.Chats.AddGroup(name, avatar, append(users, int(userId)))
+	chatId, err := d.db.Chats.AddGroup(name, avatar, append(users, int(userId)), int(userId))
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +71,13 @@ func (d *ChatsAPI) AddGroup(name, avatar string, users []int, userId UserID, eve
 	if err != nil {
 		return nil, err
 	}
+	d.db.Audit.Add(data.AuditRecord{
+		Action:   data.AuditChatAdd,
+		ActorID:  int(userId),
+		DeviceID: int(deviceId),
+		IP:       string(ip),
+		ChatID:   chatId,
+	}, map[string]interface{}{"name": name, "users": info.Users})
 
 	events.Publish("chats", ChatEvent{Op: "add", ChatID: chatId, Data: info, UserId: int(userId)})
 
@@ -83,7 +108,7 @@ func (d *ChatsAPI) SetUsers(chatId int, users []int, userId UserID, events *remo
 
 	oldUsers := d.db.UsersCache.GetUsers(chatId)
 	updUsers := append(users, int(userId))
-	chatId, err := d.db.Chats.SetUsers(chatId, updUsers)
+	chatId, err := d.db.Chats.SetUsers(chatId, updUsers, int(userId))
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +122,27 @@ func (d *ChatsAPI) SetUsers(chatId int, users []int, userId UserID, events *remo
 	return d.getChatInfo(chatId, int(userId), events, oldUsers)
 }
 
+func (d *ChatsAPI) SetSlowMode(chatId, seconds int, userId UserID, events *remote.Hub) (*data.UserChatDetails, error) {
+	if !d.db.Chats.IsAdmin(chatId, int(userId)) {
+		return nil, data.ErrAccessDenied
+	}
+
+	err := d.db.Chats.SetSlowMode(chatId, seconds)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getChatInfo(chatId, int(userId), events, nil)
+}
+
+func (d *ChatsAPI) SetRetention(chatId, days int, userId UserID) error {
+	if !d.db.Chats.IsAdmin(chatId, int(userId)) {
+		return data.ErrAccessDenied
+	}
+
+	return d.db.Chats.SetRetention(chatId, days)
+}
+
 func (d *ChatsAPI) Leave(chatId int, userId UserID, events *remote.Hub) error {
 	if !d.db.UsersCache.HasChat(int(userId), chatId) {
 		return data.ErrAccessDenied
@@ -131,6 +177,14 @@ func (d *ChatsAPI) Leave(chatId int, userId UserID, events *remote.Hub) error {
 	return nil
 }
 
+func (d *ChatsAPI) GetFiles(chatId int, kind string, cursor int, userId UserID) (*data.SharedPage, error) {
+	if !d.db.UsersCache.HasChat(int(userId), chatId) {
+		return nil, data.ErrAccessDenied
+	}
+
+	return d.db.Attachments.GetShared(data.SharedFilter{ChatID: chatId, Kind: kind, Cursor: cursor})
+}
+
 func (d *ChatsAPI) getChatInfo(chatId, userId int, events *remote.Hub, targetUsers []int) (*data.UserChatDetails, error) {
 	info, err := d.db.UserChats.GetOne(chatId, int(userId))
 	if err != nil {
@@ -147,5 +201,6 @@ func (d *ChatsAPI) sendChatInfo(chatId, userId int, info *data.UserChatDetails,
 	einfo.DirectID = 0
 	einfo.UnreadCount = 0
 	einfo.Status = 0
+	einfo.Role = 0
 	events.Publish("chats", ChatEvent{Op: "update", ChatID: chatId, Data: &einfo, UserId: userId, Users: targetUsers})
 }
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"mkozhukh/chat/data"
+	"mkozhukh/chat/service"
+	"net/url"
+	"strings"
+)
+
+type FilesAPI struct {
+	db   *data.DAO
+	sAll *service.ServiceAll
+}
+
+// SignURL returns a short-lived url of the file or the avatar, which can be used without token
+func (d *FilesAPI) SignURL(link string, userId UserID) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	// positions are relative to the route, the public url of the server can have its own path
+	route := d.sAll.FileLinks.RoutePath(u)
+	if !strings.HasPrefix(route, "/api/v1/") {
+		return "", data.ErrWrongValue
+	}
+	parts := strings.Split(strings.TrimPrefix(route, "/api/v1/"), "/")
+
+	if len(parts) == 4 && parts[0] == "chat" && parts[2] == "avatar" {
+		// chat/{chatId}/avatar/{name}
+		if !d.db.Chats.CanSeeAvatar(parts[3], int(userId)) {
+			return "", data.ErrAccessDenied
+		}
+	} else if (len(parts) == 3 || len(parts) == 4 && parts[2] == "preview") && parts[0] == "files" {
+		// files/{fileId}/{name} or files/{fileId}/preview/{name}
+		f := d.db.Files.GetOne(parts[1])
+		if !d.db.Files.CanDownload(f, int(userId)) {
+			return "", data.ErrAccessDenied
+		}
+	} else {
+		return "", data.ErrWrongValue
+	}
+
+	return d.sAll.FileLinks.Sign(link)
+}
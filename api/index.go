@@ -13,16 +13,11 @@ import (
 
 type UserID int
 type DeviceID int
+type ClientIP string
 type UserList []data.User
 type ChatList []data.UserChatDetails
 
-type UserEvent struct {
-	Op     string      `json:"op"`
-	UserID int         `json:"user_id"`
-	Data   interface{} `json:"data"`
-}
-
-func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.LivekitConfig, bConfig service.BotsConfig) *remote.Server {
+func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.LivekitConfig, sfuConfig service.SFUConfig, bConfig service.BotsConfig, lConfig service.RateLimitConfig) (*remote.Server, *service.ServiceAll) {
 	if remote.MaxSocketMessageSize < 32000 {
 		remote.MaxSocketMessageSize = 32000
 	}
@@ -31,11 +26,11 @@ func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.Livek
 		WebSocket: true,
 	})
 
-	if data.Features.WithGroupCalls && !lkConfig.Enabled {
+	sAll := service.NewService(db, api.Events, lkConfig, sfuConfig, lConfig)
+	// the built-in SFU is not created if it fails to start
+	if data.Features.WithGroupCalls && sAll.Livekit == nil && sAll.SFU == nil {
 		data.Features.WithGroupCalls = false
 	}
-
-	sAll := service.NewService(db, api.Events, lkConfig)
 	sAll.Bots.AddBot(&service.DummyLengthBot{ID: 100})
 	if bConfig.OpenAI.Enabled {
 		if bConfig.OpenAI.Proxy != "" {
@@ -100,19 +95,27 @@ func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.Livek
 		return false
 	})
 
+	api.Events.AddGuard("users", func(m *remote.Message, c *remote.Client) bool {
+		tm, ok := m.Content.(service.UserEvent)
+		if !ok {
+			return false
+		}
+
+		if tm.Op == "seen" {
+			return db.Users.CanSeeLastSeen(tm.UserID, tm.Privacy, c.User)
+		}
+		return true
+	})
+
 	api.Events.UserHandler = func(u *remote.UserChange) {
-		status := data.StatusOnline
 		if !u.Status {
-			status = data.StatusOffline
 			go sAll.Calls.SetReconnectingStaus(&service.CallContext{
 				UserID:   u.ID,
 				DeviceID: u.Connection,
 			})
+			// the last connection of the user doesn't reach ConnHandler
+			go sAll.UsersActivity.ChangeDevicePresence(u.ID, u.Connection, data.StatusOffline)
 		}
-		go (func() {
-			api.Events.Publish("users", UserEvent{Op: "online", UserID: u.ID, Data: status})
-			db.Users.ChangeOnlineStatus(u.ID, status)
-		})()
 	}
 
 	api.Events.ConnHandler = func(u *remote.UserChange) {
@@ -125,6 +128,7 @@ func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.Livek
 			})
 		}
 		go sAll.UsersActivity.ChangeOnlineStatus(u.Connection, status)
+		go sAll.UsersActivity.ChangeDevicePresence(u.ID, u.Connection, status)
 	}
 
 	api.Connect = func(r *http.Request) (context.Context, error) {
@@ -145,6 +149,8 @@ func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.Livek
 	must(api.AddService("message", &MessagesAPI{db, sAll, features}))
 	must(api.AddService("chat", &ChatsAPI{db, sAll}))
 	must(api.AddService("call", &CallsAPI{db, sAll}))
+	must(api.AddService("user", &UsersAPI{db, sAll}))
+	must(api.AddService("file", &FilesAPI{db, sAll}))
 
 	// provide user's id
 	must(api.AddVariable("user", UserID(0)))
@@ -159,7 +165,7 @@ func BuildAPI(db *data.DAO, features data.FeaturesConfig, lkConfig service.Livek
 	must(sAll.Calls.DropAllCalls(data.CallStatusLost))
 
 	handleDependencies(api, db)
-	return api
+	return api, sAll
 }
 
 func handleDependencies(api *remote.Server, db *data.DAO) {
@@ -171,13 +177,18 @@ func handleDependencies(api *remote.Server, db *data.DAO) {
 		id, _ := ctx.Value("device_id").(int)
 		return DeviceID(id)
 	}))
+	must(api.Dependencies.AddProvider(func(ctx context.Context) ClientIP {
+		ip, _ := ctx.Value("ip").(string)
+		return ClientIP(ip)
+	}))
 	must(api.Dependencies.AddProvider(func(ctx context.Context) ChatList {
 		id, _ := ctx.Value("user_id").(int)
 		u, _ := db.UserChats.GetAll(id)
 		return u
 	}))
 	must(api.Dependencies.AddProvider(func(ctx context.Context) UserList {
-		u, _ := db.Users.GetAll()
+		id, _ := ctx.Value("user_id").(int)
+		u, _ := db.Users.GetAllFor(id)
 		return u
 	}))
 	must(api.Dependencies.AddProvider(func(ctx context.Context) *remote.Hub {
@@ -225,6 +236,7 @@ func handleDependencies(api *remote.Server, db *data.DAO) {
 			IsGroupCall: call.IsGroupCall,
 			ChatID:      call.ChatID,
 			Users:       call.GetUsersIDs(false),
+			Guests:      service.CallGuests(&call),
 		}
 	}))
 }
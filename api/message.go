@@ -36,11 +36,17 @@ func (m *MessagesAPI) ResetCounter(chatId int, userId UserID) error {
 	return nil
 }
 
-func (m *MessagesAPI) Add(text string, chatId int, origin string, userId UserID, deviceId DeviceID, events *remote.Hub) (*data.Message, error) {
+func (m *MessagesAPI) Add(text string, chatId int, origin string, files []string, userId UserID, deviceId DeviceID, events *remote.Hub) (*data.Message, error) {
 	if !m.db.UsersCache.HasChat(int(userId), chatId) {
 		return nil, data.ErrAccessDenied
 	}
-
+	err := m.sAll.Limits.Take("message.Add", int(userId), int(deviceId))
+	if err == nil {
+		err = m.sAll.Limits.CheckSlowMode(chatId, int(userId))
+	}
+	if err != nil {
+		return nil, err
+	}
 	msg := data.Message{
 		Text:   data.SafeHTML(text),
 		ChatID: chatId,
@@ -48,7 +54,20 @@ func (m *MessagesAPI) Add(text string, chatId int, origin string, userId UserID,
 		Date:   time.Now(),
 	}
 
-	err := m.db.Messages.SaveAndSend(chatId, &msg, origin, int(deviceId))
+	if len(files) > 0 {
+		if !m.config.WithFiles {
+			return nil, data.ErrFeatureDisabled
+		}
+
+		msg.Attachments, err = m.db.Attachments.GetDrafts(files, chatId, int(userId))
+		if err != nil {
+			return nil, err
+		}
+		msg.Type = data.AttachedFile
+		msg.Related = msg.Attachments[0].FileID
+	}
+
+	err = m.db.Messages.SaveAndSend(chatId, &msg, origin, int(deviceId))
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +84,12 @@ func (m *MessagesAPI) Add(text string, chatId int, origin string, userId UserID,
 	return &msg, nil
 }
 
-func (m *MessagesAPI) Update(msgID int, text string, userId UserID, deviceId DeviceID, events *remote.Hub) (*data.Message, error) {
+func (m *MessagesAPI) Update(msgID int, text string, userId UserID, deviceId DeviceID, ip ClientIP, events *remote.Hub) (*data.Message, error) {
+	err := m.sAll.Limits.Take("message.Update", int(userId), int(deviceId))
+	if err != nil {
+		return nil, err
+	}
+
 	msg, err := m.db.Messages.GetOne(msgID)
 	if err != nil {
 		return nil, err
@@ -75,6 +99,7 @@ func (m *MessagesAPI) Update(msgID int, text string, userId UserID, deviceId Dev
 		return nil, data.ErrAccessDenied
 	}
 
+	prevText := msg.Text
 	msg.Text = data.SafeHTML(text)
 	msg.Edited = true
 
@@ -87,6 +112,14 @@ func (m *MessagesAPI) Update(msgID int, text string, userId UserID, deviceId Dev
 	if err != nil {
 		return nil, err
 	}
+	m.db.Audit.Add(data.AuditRecord{
+		Action:   data.AuditMessageUpdate,
+		ActorID:  int(userId),
+		DeviceID: int(deviceId),
+		IP:       string(ip),
+		ChatID:   msg.ChatID,
+		TargetID: msg.ID,
+	}, map[string]string{"from": prevText, "to": msg.Text})
 
 	events.Publish("messages", data.MessageEvent{Op: "update", Msg: msg, From: int(deviceId)})
 	if ch.LastMessage == msg.ID {
@@ -102,7 +135,12 @@ func (m *MessagesAPI) Update(msgID int, text string, userId UserID, deviceId Dev
 	return msg, nil
 }
 
-func (m *MessagesAPI) Remove(msgID int, userId UserID, deviceId DeviceID, events *remote.Hub) error {
+func (m *MessagesAPI) Remove(msgID int, userId UserID, deviceId DeviceID, ip ClientIP, events *remote.Hub) error {
+	err := m.sAll.Limits.Take("message.Remove", int(userId), int(deviceId))
+	if err != nil {
+		return err
+	}
+
 	msg, err := m.db.Messages.GetOne(msgID)
 	if err != nil {
 		return err
@@ -116,6 +154,14 @@ func (m *MessagesAPI) Remove(msgID int, userId UserID, deviceId DeviceID, events
 	if err != nil {
 		return err
 	}
+	m.db.Audit.Add(data.AuditRecord{
+		Action:   data.AuditMessageRemove,
+		ActorID:  int(userId),
+		DeviceID: int(deviceId),
+		IP:       string(ip),
+		ChatID:   msg.ChatID,
+		TargetID: msg.ID,
+	}, map[string]interface{}{"text": msg.Text, "type": msg.Type})
 
 	ch, err := m.db.Chats.GetOne(msg.ChatID)
 	if err != nil {
@@ -142,6 +188,10 @@ func (m *MessagesAPI) AddReaction(msgID int, reaction string, userId UserID, dev
 	if !m.config.WithReactions {
 		return nil, data.ErrFeatureDisabled
 	}
+	err := m.sAll.Limits.Take("message.AddReaction", int(userId), int(deviceId))
+	if err != nil {
+		return nil, err
+	}
 
 	msg, err := m.db.Messages.GetOne(msgID)
 	if err != nil {
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"mkozhukh/chat/data"
+	"mkozhukh/chat/service"
+)
+
+type UsersAPI struct {
+	db   *data.DAO
+	sAll *service.ServiceAll
+}
+
+func (d *UsersAPI) SetStatus(status int, userId UserID, deviceId DeviceID) error {
+	if status != data.StatusOnline && status != data.StatusAway && status != data.StatusDoNotDisturb {
+		return data.ErrWrongValue
+	}
+
+	d.sAll.UsersActivity.ChangeDevicePresence(int(userId), int(deviceId), status)
+	return nil
+}
+
+func (d *UsersAPI) SetLastSeenPrivacy(privacy int, userId UserID) error {
+	return d.db.Users.SetLastSeenPrivacy(int(userId), privacy)
+}
+
+func (d *UsersAPI) SetChatPrivacy(direct, groups int, userId UserID) error {
+	return d.db.Users.SetChatPrivacy(int(userId), direct, groups)
+}
+
+func (d *UsersAPI) Block(targetUserId int, userId UserID) error {
+	return d.db.Blocks.Add(int(userId), targetUserId)
+}
+
+func (d *UsersAPI) Unblock(targetUserId int, userId UserID) error {
+	return d.db.Blocks.Remove(int(userId), targetUserId)
+}
+
+func (d *UsersAPI) GetBlocked(userId UserID) ([]int, error) {
+	return d.db.Blocks.GetAll(int(userId))
+}
+
+func (d *UsersAPI) GetSessions(userId UserID, deviceId DeviceID) ([]data.Session, error) {
+	return d.sAll.Sessions.GetAll(int(userId), int(deviceId))
+}
+
+func (d *UsersAPI) RevokeSession(id int, userId UserID) error {
+	return d.sAll.Sessions.Revoke(int(userId), id)
+}
+
+// GetFiles returns files and links shared by the user in all chats
+func (d *UsersAPI) GetFiles(kind string, cursor int, userId UserID) (*data.SharedPage, error) {
+	return d.db.Attachments.GetShared(data.SharedFilter{UserID: int(userId), Kind: kind, Cursor: cursor})
+}
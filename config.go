@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"mkozhukh/chat/data"
+	"mkozhukh/chat/media"
+	"mkozhukh/chat/scanner"
 	"mkozhukh/chat/service"
+	"mkozhukh/chat/storage"
 
 	"github.com/jinzhu/configor"
 )
@@ -14,10 +17,12 @@ var Config AppConfig
 // AppConfig contains app's configuration
 type AppConfig struct {
 	Server struct {
-		Stun   string
-		Port   string `default:":80"`
-		Data   string `default:"./storage"`
-		Public string
+		Stun       string
+		Port       string `default:":80"`
+		Data       string `default:"./storage"`
+		Public     string
+		AdminKey   string
+		SigningKey string
 	}
 	DB struct {
 		User     string
@@ -28,7 +33,16 @@ type AppConfig struct {
 	}
 	Features data.FeaturesConfig
 	Livekit  service.LivekitConfig
+	SFU      service.SFUConfig
+	Turn     service.TurnConfig
 	Bots     service.BotsConfig
+	Limits   service.RateLimitConfig
+	Storage  storage.Config
+	Uploads  data.UploadsConfig
+	Media    media.Config
+	Scanner  scanner.Config
+	Quotas   data.QuotasConfig
+	Presence service.PresenceConfig
 }
 
 // LoadFromFile method loads and parses config file
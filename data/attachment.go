@@ -0,0 +1,383 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type AttachmentsDAO struct {
+	dao *DAO
+	db  *gorm.DB
+}
+
+type Attachment struct {
+	ID        int     `gorm:"primary_key" json:"-"`
+	MessageID int     `gorm:"index" json:"-"`
+	FileID    int     `gorm:"index" json:"-"`
+	UID       string  `gorm:"column:uid" json:"id"`
+	Name      string  `json:"name"`
+	URL       string  `json:"url"`
+	MimeType  string  `json:"mime"`
+	Size      int64   `json:"size"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Preview   string  `json:"preview,omitempty"`
+	Checksum  string  `json:"checksum"`
+	Status    string  `gorm:"not null;default:''" json:"status,omitempty"`
+
+	Previews PreviewLinks `gorm:"type:text" json:"previews,omitempty"`
+	Waveform Peaks        `gorm:"type:text" json:"waveform,omitempty"`
+
+	// drafts are not linked to a message yet
+	UserID  int        `json:"-"`
+	Created *time.Time `json:"-"`
+}
+
+// PreviewLinks are urls of previews by their max side
+type PreviewLinks map[string]string
+
+func (p PreviewLinks) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "", nil
+	}
+	v, err := json.Marshal(p)
+	return string(v), err
+}
+
+func (p *PreviewLinks) Scan(value interface{}) error {
+	var v []byte
+	switch t := value.(type) {
+	case []byte:
+		v = t
+	case string:
+		v = []byte(t)
+	}
+
+	*p = nil
+	if len(v) == 0 {
+		return nil
+	}
+	return json.Unmarshal(v, p)
+}
+
+// Peaks is the waveform of a voice message
+type Peaks []int
+
+func (p Peaks) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "", nil
+	}
+	v, err := json.Marshal(p)
+	return string(v), err
+}
+
+func (p *Peaks) Scan(value interface{}) error {
+	var v []byte
+	switch t := value.(type) {
+	case []byte:
+		v = t
+	case string:
+		v = []byte(t)
+	}
+
+	*p = nil
+	if len(v) == 0 {
+		return nil
+	}
+	return json.Unmarshal(v, p)
+}
+
+var getAttachmentsSQL = "select a.* from messages m join attachments a on m.id = a.message_id and m.chat_id = ? order by a.id"
+var getDraftsSQL = "select a.* from attachments a join files f on f.id = a.file_id where a.uid in (?) and a.message_id = 0 and a.user_id = ? and f.chat_id = ?"
+
+func NewAttachmentsDAO(dao *DAO, db *gorm.DB) AttachmentsDAO {
+	return AttachmentsDAO{dao, db}
+}
+
+// Save stores new attachments of the message and links drafts to it
+func (d *AttachmentsDAO) Save(msg *Message) error {
+	for i := range msg.Attachments {
+		a := &msg.Attachments[i]
+		if a.ID != 0 && a.MessageID == msg.ID {
+			continue
+		}
+
+		var err error
+		a.MessageID = msg.ID
+		if a.ID == 0 {
+			err = d.db.Create(a).Error
+		} else {
+			err = d.db.Model(a).Update("message_id", msg.ID).Error
+		}
+		logError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *AttachmentsDAO) AddDraft(a *Attachment) error {
+	now := time.Now()
+	a.MessageID = 0
+	a.Created = &now
+
+	err := d.db.Create(a).Error
+	logError(err)
+
+	return err
+}
+
+// GetDrafts returns not sent attachments of the user, uploaded to the chat
+func (d *AttachmentsDAO) GetDrafts(ids []string, chatId, userId int) ([]Attachment, error) {
+	out := make([]Attachment, 0, len(ids))
+	err := d.db.Raw(getDraftsSQL, ids, userId, chatId).Scan(&out).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != len(ids) {
+		return nil, ErrAccessDenied
+	}
+
+	// keep the order of the gallery
+	byID := make(map[string]Attachment, len(out))
+	for _, a := range out {
+		byID[a.UID] = a
+	}
+	for i, id := range ids {
+		out[i] = byID[id]
+	}
+
+	return out, nil
+}
+
+// RemoveExpiredDrafts deletes drafts uploaded before the time, along with their files
+func (d *AttachmentsDAO) RemoveExpiredDrafts(before time.Time) (int, error) {
+	drafts := make([]Attachment, 0)
+	err := d.db.Where("message_id = 0 AND created < ?", before).Find(&drafts).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, a := range drafts {
+		err = d.dao.Files.Delete(a.FileID)
+		if err == nil {
+			err = d.db.Delete(&a).Error
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(drafts), nil
+}
+
+// DeleteForMessage removes attachments of the message along with their stored files, so usage matches the storage
+func (d *AttachmentsDAO) DeleteForMessage(msgId int) error {
+	all, err := d.GetAllForMessage(msgId)
+	if err != nil {
+		return err
+	}
+
+	for i := range all {
+		a := &all[i]
+		err = d.db.Delete(a).Error
+		if err == nil && a.FileID != 0 && !d.isFileUsed(a.FileID) {
+			err = d.dao.Files.Delete(a.FileID)
+		}
+		logError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isFileUsed checks that the file is attached to some other message
+func (d *AttachmentsDAO) isFileUsed(fileId int) bool {
+	var count int
+	err := d.db.Model(&Attachment{}).Where("file_id = ?", fileId).Count(&count).Error
+	logError(err)
+
+	return err != nil || count > 0
+}
+
+func (d *AttachmentsDAO) GetAllForMessage(msgId int) ([]Attachment, error) {
+	out := make([]Attachment, 0)
+	err := d.db.Where("message_id = ?", msgId).Order("id").Find(&out).Error
+	logError(err)
+
+	return out, err
+}
+
+func (d *AttachmentsDAO) GetAllForChat(chatId int) ([]Attachment, error) {
+	out := make([]Attachment, 0)
+	err := d.db.Raw(getAttachmentsSQL, chatId).Scan(&out).Error
+	logError(err)
+
+	return out, err
+}
+
+func (d *AttachmentsDAO) SetAttachments(msgs []Message, all []Attachment) {
+	byMessage := make(map[int][]Attachment)
+	for _, a := range all {
+		byMessage[a.MessageID] = append(byMessage[a.MessageID], a)
+	}
+
+	for i := range msgs {
+		msgs[i].Attachments = byMessage[msgs[i].ID]
+	}
+}
+
+// newAttachment collects metadata of the stored file
+func newAttachment(f *File, file io.ReadSeeker, size int64, server string) Attachment {
+	a := Attachment{
+		FileID: f.ID,
+		UID:    f.UID,
+		Name:   f.Name,
+		URL:    getFileURL(server, f.UID, f.Name),
+		Size:   size,
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	a.MimeType = detectMimeType(f.Name, head[:n])
+	file.Seek(0, io.SeekStart)
+
+	if strings.HasPrefix(a.MimeType, "image/") {
+		cfg, _, err := image.DecodeConfig(file)
+		if err == nil {
+			a.Width = cfg.Width
+			a.Height = cfg.Height
+		}
+		file.Seek(0, io.SeekStart)
+	}
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, file)
+	if err == nil {
+		a.Checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+	file.Seek(0, io.SeekStart)
+
+	return a
+}
+
+func detectMimeType(name string, head []byte) string {
+	byName := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	byContent := http.DetectContentType(head)
+
+	// content detection can't recognize many formats, so trust the extension in such case
+	if byName != "" && (byContent == "application/octet-stream" || strings.HasPrefix(byContent, "text/plain")) {
+		return byName
+	}
+	return byContent
+}
+
+const fileMessagesMigration = "file-messages"
+
+// MigrateFileMessages converts the text of file and voice messages from older versions to attachments
+func (d *AttachmentsDAO) MigrateFileMessages() error {
+	if d.dao.isMigrated(fileMessagesMigration) {
+		return nil
+	}
+
+	msgs := make([]Message, 0)
+	// messages with attachments can have a caption
+	err := d.db.
+		Where("type IN (?) AND text <> ?", []int{AttachedFile, VoiceMessage}, "").
+		Where("id NOT IN (?)", d.db.Table("attachments").Select("message_id").QueryExpr()).
+		Find(&msgs).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+		f := File{}
+		if m.Related != 0 {
+			d.db.Where("id = ?", m.Related).First(&f)
+		}
+
+		parts := strings.Split(m.Text, "\n")
+		a := Attachment{FileID: f.ID, UID: f.UID, Name: f.Name, URL: parts[0]}
+		if m.Type == VoiceMessage {
+			if len(parts) > 1 {
+				a.Duration, _ = strconv.ParseFloat(parts[1], 64)
+			}
+		} else {
+			if len(parts) > 1 {
+				a.Name = parts[1]
+			}
+			if len(parts) > 2 {
+				a.Size, _ = strconv.ParseInt(parts[2], 10, 64)
+			}
+			if len(parts) > 3 {
+				a.Preview = parts[3]
+			}
+		}
+		if f.ID != 0 {
+			d.fillStoredInfo(&f, &a)
+		}
+		if a.MimeType == "" {
+			a.MimeType = detectMimeType(a.Name, nil)
+		}
+
+		// the text is the only source of the attachment, so it is cleared along with its creation
+		err = d.db.Transaction(func(tx *gorm.DB) error {
+			a.MessageID = m.ID
+			err := tx.Create(&a).Error
+			if err == nil {
+				err = tx.Model(m).Update("text", "").Error
+			}
+			return err
+		})
+		logError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(msgs) > 0 {
+		log.Printf("[attachments] %d messages converted", len(msgs))
+	}
+	return d.dao.setMigrated(fileMessagesMigration)
+}
+
+func (d *AttachmentsDAO) fillStoredInfo(f *File, a *Attachment) {
+	if d.dao.Storage == nil {
+		return
+	}
+
+	source, info, err := d.dao.Storage.Open(f.Path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	if rs, ok := source.(io.ReadSeeker); ok {
+		stored := newAttachment(f, rs, info.Size, "")
+		a.MimeType = stored.MimeType
+		a.Width = stored.Width
+		a.Height = stored.Height
+		a.Checksum = stored.Checksum
+		a.Size = info.Size
+	}
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	AuditTokenIssue    = "token.issue"
+	AuditTokenInvalid  = "token.invalid"
+	AuditChatAdd       = "chat.add"
+	AuditChatUserAdd   = "chat.user.add"
+	AuditChatUserLeave = "chat.user.leave"
+	AuditMessageUpdate = "message.update"
+	AuditMessageRemove = "message.remove"
+	AuditFileDownload  = "file.download"
+	AuditCallStart     = "call.start"
+	AuditCallJoin      = "call.join"
+	AuditCallEnd       = "call.end"
+)
+
+type AuditDAO struct {
+	db *gorm.DB
+}
+
+type AuditRecord struct {
+	ID       int       `gorm:"primary_key" json:"id"`
+	Date     time.Time `gorm:"index" json:"date"`
+	Action   string    `gorm:"index" json:"action"`
+	ActorID  int       `gorm:"index" json:"actor"`
+	DeviceID int       `json:"device"`
+	IP       string    `json:"ip"`
+	ChatID   int       `gorm:"index" json:"chat"`
+	TargetID int       `json:"target"`
+	Payload  string    `gorm:"type:text" json:"payload"`
+}
+
+type AuditFilter struct {
+	ActorID int
+	ChatID  int
+	Action  string
+	From    *time.Time
+	To      *time.Time
+	Limit   int
+}
+
+func NewAuditDAO(db *gorm.DB) AuditDAO {
+	return AuditDAO{db}
+}
+
+func (d *AuditDAO) Add(r AuditRecord, payload interface{}) {
+	if payload != nil {
+		bytes, _ := json.Marshal(payload)
+		r.Payload = string(bytes)
+	}
+	r.ID = 0
+	r.Date = time.Now()
+
+	// records are never updated, so create them only
+	err := d.db.Create(&r).Error
+	logError(err)
+}
+
+func (d *AuditDAO) Find(f AuditFilter) ([]AuditRecord, error) {
+	q := d.db.Order("date desc")
+	if f.ActorID != 0 {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if f.ChatID != 0 {
+		q = q.Where("chat_id = ?", f.ChatID)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.From != nil {
+		q = q.Where("date >= ?", f.From)
+	}
+	if f.To != nil {
+		q = q.Where("date < ?", f.To)
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	out := make([]AuditRecord, 0)
+	err := q.Find(&out).Error
+	logError(err)
+
+	return out, err
+}
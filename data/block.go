@@ -0,0 +1,116 @@
+package data
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrPrivacyRestricted = errors.New("restricted by privacy settings")
+
+type BlocksDAO struct {
+	dao *DAO
+	db  *gorm.DB
+}
+
+type UserBlock struct {
+	UserID    int `gorm:"primary_key;auto_increment:false"`
+	BlockedID int `gorm:"primary_key;auto_increment:false"`
+}
+
+func NewBlocksDAO(dao *DAO, db *gorm.DB) BlocksDAO {
+	return BlocksDAO{dao, db}
+}
+
+func (d *BlocksDAO) Add(userId, blockedId int) error {
+	if userId == blockedId {
+		return ErrWrongValue
+	}
+	if d.IsBlocked(userId, blockedId) {
+		return nil
+	}
+
+	err := d.db.Create(&UserBlock{UserID: userId, BlockedID: blockedId}).Error
+	logError(err)
+
+	return err
+}
+
+func (d *BlocksDAO) Remove(userId, blockedId int) error {
+	err := d.db.Delete(&UserBlock{}, "user_id = ? AND blocked_id = ?", userId, blockedId).Error
+	logError(err)
+
+	return err
+}
+
+func (d *BlocksDAO) GetAll(userId int) ([]int, error) {
+	blocks := make([]UserBlock, 0)
+	err := d.db.Where("user_id = ?", userId).Find(&blocks).Error
+	logError(err)
+
+	out := make([]int, len(blocks))
+	for i := range blocks {
+		out[i] = blocks[i].BlockedID
+	}
+
+	return out, err
+}
+
+// IsBlocked checks if the user has blocked the other one
+func (d *BlocksDAO) IsBlocked(userId, blockedId int) bool {
+	var count int
+	err := d.db.Model(&UserBlock{}).
+		Where("user_id = ? AND blocked_id = ?", userId, blockedId).
+		Count(&count).Error
+	logError(err)
+
+	return count > 0
+}
+
+// IsBlockedBetween checks if any of two users has blocked the other one
+func (d *BlocksDAO) IsBlockedBetween(userId, otherId int) bool {
+	var count int
+	err := d.db.Model(&UserBlock{}).
+		Where("(user_id = ? AND blocked_id = ?) OR (user_id = ? AND blocked_id = ?)", userId, otherId, otherId, userId).
+		Count(&count).Error
+	logError(err)
+
+	return count > 0
+}
+
+// CanWriteDirect checks if the user can start a direct chat or a call with the target user
+func (d *BlocksDAO) CanWriteDirect(userId, targetId int) error {
+	if d.IsBlockedBetween(userId, targetId) {
+		return ErrAccessDenied
+	}
+
+	target, err := d.dao.Users.GetOne(targetId)
+	if err != nil {
+		return err
+	}
+	if target.DirectPrivacy == PrivacyContacts && !d.dao.UsersCache.IsContact(targetId, userId) {
+		return ErrPrivacyRestricted
+	}
+
+	return nil
+}
+
+// CanAddToGroup checks if the user can add the target user to a group chat
+func (d *BlocksDAO) CanAddToGroup(userId, targetId int) error {
+	if userId == targetId {
+		return nil
+	}
+	if d.IsBlocked(targetId, userId) {
+		return ErrAccessDenied
+	}
+
+	target, err := d.dao.Users.GetOne(targetId)
+	if err != nil {
+		return err
+	}
+	if target.GroupsPrivacy == PrivacyContacts && !d.dao.UsersCache.IsContact(targetId, userId) {
+		return ErrPrivacyRestricted
+	}
+
+	return nil
+}
@@ -51,6 +51,22 @@ func (cache *UsersCache) HasChat(userId, chatId int) bool {
 	return has
 }
 
+// IsContact checks if two users have at least one common chat
+func (cache *UsersCache) IsContact(userId, otherId int) bool {
+	c, ok := cache.Users[userId]
+	if !ok {
+		c = cache.fillUsers(userId)
+	}
+
+	for chatId := range c {
+		if cache.HasChat(otherId, chatId) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (cache *UsersCache) GetChats(userId int) []int {
 	c, ok := cache.Users[userId]
 	if !ok {
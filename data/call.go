@@ -27,11 +27,14 @@ type CallsDAO struct {
 type Call struct {
 	ID          int        `gorm:"primary_key"`
 	Start       *time.Time `gorm:"column:start"`
+	End         *time.Time `gorm:"column:ended"`
 	Status      int        `gorm:"column:status"`
 	InitiatorID int        `gorm:"column:initiator_id"`
 	ChatID      int        `gorm:"column:chat_id"`
 	IsGroupCall bool       `gorm:"column:is_group"`
 	RoomName    string     `gorm:"column:room_name"`
+	// new participants can't join the locked call
+	Locked bool `gorm:"column:locked"`
 
 	Users []CallUser `sql:"-"`
 }
@@ -41,6 +44,11 @@ func NewCallsDAO(dao *DAO, db *gorm.DB) CallsDAO {
 }
 
 func (d *CallsDAO) Start(from, device, to, chatId int) (Call, error) {
+	err := d.dao.Chats.CheckDirectAccess(chatId, from)
+	if err != nil {
+		return Call{}, err
+	}
+
 	c := Call{
 		InitiatorID: from,
 		Status:      CallStatusInitiated,
@@ -48,7 +56,7 @@ func (d *CallsDAO) Start(from, device, to, chatId int) (Call, error) {
 		ChatID:      chatId,
 	}
 
-	err := d.db.Save(&c).Error
+	err = d.db.Save(&c).Error
 	if err != nil {
 		return c, err
 	}
@@ -78,10 +86,11 @@ func (d *CallsDAO) Get(id int) (Call, error) {
 func (d *CallsDAO) GetByUser(id int) (Call, error) {
 	sql := "SELECT `c`.* FROM `calls` `c` " +
 		"JOIN `call_user` `cu` ON `c`.`id` = `cu`.`call_id` AND `cu`.`status` > ? AND `cu`.`user_id` = ? " +
-		"WHERE `c`.`status` < 900"
+		"WHERE `c`.`status` < 900 " +
+		heldLastSQL
 
 	c := Call{}
-	err := d.db.Raw(sql, CallUserStatusDisconnected, id).Scan(&c).Error
+	err := d.db.Raw(sql, CallUserStatusDisconnected, id, CallUserStatusHeld).Scan(&c).Error
 	if err != nil {
 		if errors.Is(gorm.ErrRecordNotFound, err) {
 			err = nil
@@ -99,6 +108,31 @@ func (d *CallsDAO) GetByUser(id int) (Call, error) {
 	return c, err
 }
 
+// calls on hold are returned only if the user has no other calls
+var heldLastSQL = "ORDER BY CASE WHEN `cu`.`status` = ? THEN 1 ELSE 0 END, `c`.`id` DESC"
+
+// GetAllByUser returns not ended calls of the user, including the ones on hold
+func (d *CallsDAO) GetAllByUser(id int) ([]Call, error) {
+	sql := "SELECT `c`.* FROM `calls` `c` " +
+		"JOIN `call_user` `cu` ON `c`.`id` = `cu`.`call_id` AND `cu`.`status` > ? AND `cu`.`user_id` = ? " +
+		"WHERE `c`.`status` < 900"
+
+	calls := make([]Call, 0)
+	err := d.db.Raw(sql, CallUserStatusDisconnected, id).Scan(&calls).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range calls {
+		calls[i].Users, err = d.dao.CallUsers.GetCallUsers(calls[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return calls, nil
+}
+
 func (d *CallsDAO) Update(call *Call, status int) error {
 	if status == CallStatusAccepted {
 		if call.IsGroupCall && call.Status != CallStatusInitiated {
@@ -107,6 +141,18 @@ func (d *CallsDAO) Update(call *Call, status int) error {
 		status = CallStatusActive
 		currentTime := time.Now()
 		call.Start = &currentTime
+
+		// the initiator is in the call from its start, unless it was started by the schedule
+		if u := call.GetByUserID(call.InitiatorID); u != nil && u.DeviceID != 0 && u.Status != CallUserStatusDisconnected {
+			err := d.dao.CallUsers.OpenSession(call.ID, u.UserID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if status > 900 && call.End == nil {
+		currentTime := time.Now()
+		call.End = &currentTime
 	}
 
 	call.Status = status
@@ -120,10 +166,11 @@ func (d *CallsDAO) Save(call *Call) error {
 func (d *CallsDAO) GetByDevice(id int) (Call, error) {
 	sql := "SELECT `c`.* FROM `calls` `c` " +
 		"JOIN `call_user` `cu` ON `c`.`id` = `cu`.`call_id` AND `cu`.`status` > ? AND `cu`.`device_id` = ? " +
-		"WHERE `c`.`status` < 900"
+		"WHERE `c`.`status` < 900 " +
+		heldLastSQL
 
 	c := Call{}
-	err := d.db.Raw(sql, CallUserStatusDisconnected, id).Scan(&c).Error
+	err := d.db.Raw(sql, CallUserStatusDisconnected, id, CallUserStatusHeld).Scan(&c).Error
 	if err != nil {
 		if errors.Is(gorm.ErrRecordNotFound, err) {
 			err = nil
@@ -141,6 +188,24 @@ func (d *CallsDAO) GetByDevice(id int) (Call, error) {
 	return c, err
 }
 
+// GetActiveInRooms returns active calls of the media server with their participants
+func (d *CallsDAO) GetActiveInRooms() ([]Call, error) {
+	calls := make([]Call, 0)
+	err := d.db.Where("status = ? AND room_name <> ''", CallStatusActive).Find(&calls).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range calls {
+		calls[i].Users, err = d.dao.CallUsers.GetCallUsers(calls[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return calls, nil
+}
+
 func (d *CallsDAO) CheckIfChatInCall(chatId int) (Call, error) {
 	call := Call{}
 	err := d.db.
@@ -194,13 +259,20 @@ func (d *CallsDAO) DropAllCalls(status int) ([]Call, error) {
 		if err != nil {
 			return nil, err
 		}
+		err = d.dao.Users.RemoveGuests(calls[i].ID)
+		if err != nil {
+			return nil, err
+		}
 		ids[i] = calls[i].ID
 	}
 
 	err = d.db.
 		Model(&Call{}).
 		Where("id IN (?)", ids).
-		Update("status", status).
+		Updates(map[string]interface{}{
+			"status": status,
+			"ended":  time.Now(),
+		}).
 		Error
 
 	return calls, err
@@ -230,7 +302,8 @@ func (d *CallsDAO) initCallUsers(call *Call, initiatorDeviceId int) error {
 			cu.Status = CallUserStatusDisconnected
 		}
 
-		if u.UserID == call.InitiatorID {
+		// the call can be started without the device of the initiator, e.g. by the schedule
+		if u.UserID == call.InitiatorID && initiatorDeviceId != 0 {
 			cu.DeviceID = initiatorDeviceId
 			cu.Status = CallUserStatusConnecting
 		}
@@ -284,6 +357,16 @@ func (d *CallsDAO) RefreshCallUsers(call *Call, chatusers []int) ([]CallUser, []
 		}
 	}
 
+	// guests are not members of the chat
+	for _, u := range oldCallUsers {
+		if u.Guest {
+			err = d.addCallUser(call, u)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
 	for _, u := range oldCallUsers {
 		check := findUser(u.UserID, call.Users)
 		if check.UserID == 0 {
@@ -303,7 +386,7 @@ func (d *CallsDAO) addCallUser(call *Call, cu CallUser) error {
 	// add user to call
 	cu.CallID = call.ID
 
-	err := d.dao.CallUsers.AddUser(cu.CallID, cu.UserID, cu.DeviceID, cu.Status)
+	err := d.dao.CallUsers.Add(&cu)
 	if err == nil {
 		call.Users = append(call.Users, cu)
 	}
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"time"
+)
+
+const (
+	CallEventJoin  = 1
+	CallEventLeave = 2
+)
+
+const callsPageSize = 50
+
+// CallEvent is a join or a leave of the call participant
+type CallEvent struct {
+	ID     int       `gorm:"primary_key"`
+	CallID int       `gorm:"index"`
+	UserID int       `gorm:"index"`
+	Type   int       `gorm:"not null"`
+	Date   time.Time `gorm:"not null"`
+}
+
+type CallSession struct {
+	Joined time.Time  `json:"joined"`
+	Left   *time.Time `json:"left"`
+}
+
+type CallParticipant struct {
+	UserID int `json:"user_id"`
+	// time in the call, in seconds
+	Duration int           `json:"duration"`
+	Sessions []CallSession `json:"sessions"`
+}
+
+// CallRecord is a call from the log, ID is the cursor of the next page
+type CallRecord struct {
+	ID          int        `json:"id"`
+	ChatID      int        `json:"chat_id"`
+	InitiatorID int        `json:"initiator"`
+	IsGroupCall bool       `json:"group"`
+	Status      int        `json:"status"`
+	Reason      string     `json:"reason"`
+	Start       *time.Time `json:"start"`
+	End         *time.Time `json:"end"`
+	// length of the call, in seconds
+	Duration     int               `json:"duration"`
+	Participants []CallParticipant `json:"participants"`
+}
+
+type CallsPage struct {
+	Items []CallRecord `json:"items"`
+	// cursor of the next page, 0 if there are no more items
+	Next int `json:"next"`
+}
+
+// CallEndReason returns the name of the final status, or empty string for calls in progress
+func CallEndReason(status int) string {
+	switch status {
+	case CallStatusRejected:
+		return "rejected"
+	case CallStatusEnded:
+		return "ended"
+	case CallStatusIgnored:
+		return "missed"
+	case CallStatusLost:
+		return "lost"
+	case CallStatusBusy:
+		return "busy"
+	}
+	return ""
+}
+
+// GetHistory returns a page of calls of the chat, or of all chats of the user if chatId is 0
+func (d *CallsDAO) GetHistory(chatId, userId, cursor int) (*CallsPage, error) {
+	q := d.db.Model(&Call{})
+	if chatId != 0 {
+		q = q.Where("chat_id = ?", chatId)
+	} else {
+		q = q.Where("chat_id IN (select chat_id from user_chats where user_id = ?)", userId)
+	}
+	if cursor != 0 {
+		q = q.Where("id < ?", cursor)
+	}
+
+	calls := make([]Call, 0)
+	err := q.Order("id desc").Limit(callsPageSize + 1).Find(&calls).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &CallsPage{Items: make([]CallRecord, 0, len(calls))}
+	if len(calls) > callsPageSize {
+		calls = calls[:callsPageSize]
+		page.Next = calls[callsPageSize-1].ID
+	}
+	if len(calls) == 0 {
+		return page, nil
+	}
+
+	ids := make([]int, len(calls))
+	for i := range calls {
+		ids[i] = calls[i].ID
+	}
+	events := make([]CallEvent, 0)
+	err = d.db.Where("call_id IN (?)", ids).Order("id").Find(&events).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	byCall := make(map[int][]CallEvent)
+	for _, e := range events {
+		byCall[e.CallID] = append(byCall[e.CallID], e)
+	}
+
+	for _, c := range calls {
+		end := time.Now()
+		if c.End != nil {
+			end = *c.End
+		}
+
+		r := CallRecord{
+			ID:           c.ID,
+			ChatID:       c.ChatID,
+			InitiatorID:  c.InitiatorID,
+			IsGroupCall:  c.IsGroupCall,
+			Status:       c.Status,
+			Reason:       CallEndReason(c.Status),
+			Start:        c.Start,
+			End:          c.End,
+			Participants: callParticipants(byCall[c.ID], end),
+		}
+		if c.Start != nil {
+			r.Duration = int(end.Sub(*c.Start).Seconds())
+		}
+		page.Items = append(page.Items, r)
+	}
+
+	return page, nil
+}
+
+// callParticipants pairs joins and leaves of users in the order of the first join,
+// sessions which are not closed yet are counted till the end of the call
+func callParticipants(events []CallEvent, end time.Time) []CallParticipant {
+	out := make([]CallParticipant, 0)
+	index := make(map[int]int)
+
+	for _, e := range events {
+		i, ok := index[e.UserID]
+		if !ok {
+			if e.Type != CallEventJoin {
+				continue
+			}
+			i = len(out)
+			index[e.UserID] = i
+			out = append(out, CallParticipant{UserID: e.UserID, Sessions: make([]CallSession, 0, 1)})
+		}
+
+		p := &out[i]
+		last := len(p.Sessions) - 1
+		switch e.Type {
+		case CallEventJoin:
+			if last < 0 || p.Sessions[last].Left != nil {
+				p.Sessions = append(p.Sessions, CallSession{Joined: e.Date})
+			}
+		case CallEventLeave:
+			if last >= 0 && p.Sessions[last].Left == nil {
+				left := e.Date
+				p.Sessions[last].Left = &left
+			}
+		}
+	}
+
+	for i := range out {
+		for _, s := range out[i].Sessions {
+			left := end
+			if s.Left != nil && s.Left.Before(end) {
+				left = *s.Left
+			}
+			if left.After(s.Joined) {
+				out[i].Duration += int(left.Sub(s.Joined).Seconds())
+			}
+		}
+	}
+
+	return out
+}
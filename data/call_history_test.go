@@ -0,0 +1,52 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallParticipants(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	join := func(user, sec int) CallEvent { return CallEvent{UserID: user, Type: CallEventJoin, Date: at(sec)} }
+	leave := func(user, sec int) CallEvent { return CallEvent{UserID: user, Type: CallEventLeave, Date: at(sec)} }
+
+	type result struct {
+		user     int
+		duration int
+		sessions int
+	}
+
+	cases := []struct {
+		name   string
+		events []CallEvent
+		end    int
+		out    []result
+	}{
+		{"one session", []CallEvent{join(1, 0), leave(1, 60)}, 100, []result{{1, 60, 1}}},
+		{"not closed session", []CallEvent{join(1, 10)}, 40, []result{{1, 30, 1}}},
+		{"leave after the end", []CallEvent{join(1, 0), leave(1, 200)}, 100, []result{{1, 100, 1}}},
+		{"join after the end", []CallEvent{join(1, 150), leave(1, 200)}, 100, []result{{1, 0, 1}}},
+		{"rejoin", []CallEvent{join(1, 0), leave(1, 10), join(1, 20), leave(1, 30)}, 100, []result{{1, 20, 2}}},
+		{"leave without join", []CallEvent{leave(2, 5), join(1, 10), leave(1, 20)}, 100, []result{{1, 10, 1}}},
+		{"double join", []CallEvent{join(1, 0), join(1, 10), leave(1, 30)}, 100, []result{{1, 30, 1}}},
+		{"double leave", []CallEvent{join(1, 0), leave(1, 10), leave(1, 50)}, 100, []result{{1, 10, 1}}},
+		{"order of the first join", []CallEvent{join(2, 0), join(1, 5), leave(2, 10), leave(1, 20)}, 100, []result{{2, 10, 1}, {1, 15, 1}}},
+		{"no events", []CallEvent{}, 100, []result{}},
+	}
+
+	for _, c := range cases {
+		out := callParticipants(c.events, at(c.end))
+		if len(out) != len(c.out) {
+			t.Errorf("%s: %d participants, want %d", c.name, len(out), len(c.out))
+			continue
+		}
+		for i, r := range c.out {
+			p := out[i]
+			if p.UserID != r.user || p.Duration != r.duration || len(p.Sessions) != r.sessions {
+				t.Errorf("%s: participant %d is %d, %ds, %d sessions, want %d, %ds, %d sessions",
+					c.name, i, p.UserID, p.Duration, len(p.Sessions), r.user, r.duration, r.sessions)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// connection quality reported by LiveKit clients
+const (
+	CallQualityUnknown = iota
+	CallQualityPoor
+	CallQualityGood
+	CallQualityExcellent
+)
+
+type CallStatsDAO struct {
+	db *gorm.DB
+}
+
+// CallStat is a quality sample of the participant's connection
+type CallStat struct {
+	ID     int       `gorm:"primary_key" json:"id"`
+	CallID int       `gorm:"index" json:"call_id"`
+	UserID int       `gorm:"index" json:"user_id"`
+	Date   time.Time `gorm:"index" json:"date"`
+	// round trip time and jitter in milliseconds
+	RTT    float64 `json:"rtt"`
+	Jitter float64 `json:"jitter"`
+	// percent of lost packets
+	Loss float64 `json:"loss"`
+	// kbit/s
+	Bitrate float64 `json:"bitrate"`
+	Quality int     `json:"quality"`
+	// added by the server when the participant's reconnection has timed out
+	Dropped bool `json:"dropped"`
+	// taken by the server from the LiveKit room, such samples have no stats of the connection
+	Server bool `json:"server"`
+	// the participant of the call is not connected to the room
+	Absent bool `json:"absent"`
+	// the participant has connected to the room again since the previous sample
+	Rejoined bool `json:"rejoined"`
+	// published tracks, which are not muted
+	Tracks int `json:"tracks"`
+}
+
+// CallQuality is the summary of samples of the call
+type CallQuality struct {
+	CallID  int        `json:"call_id"`
+	ChatID  int        `json:"chat_id"`
+	Status  int        `json:"status"`
+	Reason  string     `gorm:"-" json:"reason"`
+	Start   *time.Time `json:"start"`
+	End     *time.Time `json:"end"`
+	Users   int        `json:"users"`
+	Samples int        `json:"samples"`
+	RTT     float64    `json:"rtt"`
+	MaxRTT  float64    `json:"max_rtt"`
+	Jitter  float64    `json:"jitter"`
+	Loss    float64    `json:"loss"`
+	MaxLoss float64    `json:"max_loss"`
+	Bitrate float64    `json:"bitrate"`
+	// samples with poor connection quality
+	Poor int `json:"poor"`
+	// participants dropped by the reconnection timeout
+	Drops int `json:"drops"`
+	// server samples of participants, which were not in the room or have reconnected to it
+	Absent  int `json:"absent"`
+	Rejoins int `json:"rejoins"`
+}
+
+// CallQualityFilter selects calls by the start time, outcome is lost or disconnected
+type CallQualityFilter struct {
+	Outcome string
+	From    *time.Time
+	To      *time.Time
+	Limit   int
+}
+
+func NewCallStatsDAO(db *gorm.DB) CallStatsDAO {
+	return CallStatsDAO{db}
+}
+
+func (d *CallStatsDAO) Add(s *CallStat) error {
+	s.ID = 0
+	err := d.db.Create(s).Error
+	logError(err)
+	return err
+}
+
+// RemoveOld deletes samples older than the time
+func (d *CallStatsDAO) RemoveOld(before time.Time) (int64, error) {
+	res := d.db.Where("date < ?", before).Delete(&CallStat{})
+	logError(res.Error)
+	return res.RowsAffected, res.Error
+}
+
+func (d *CallStatsDAO) GetByCall(callId int) ([]CallStat, error) {
+	out := make([]CallStat, 0)
+	err := d.db.Where("call_id = ?", callId).Order("id").Find(&out).Error
+	logError(err)
+	return out, err
+}
+
+// Summary returns quality of calls with samples, the latest first
+func (d *CallStatsDAO) Summary(f CallQualityFilter) ([]CallQuality, error) {
+	q := d.db.Table("calls c").
+		Select("c.id as call_id, c.chat_id, c.status, c.start, c.ended as end, "+
+			"count(distinct s.user_id) as users, sum(case when s.dropped or s.server then 0 else 1 end) as samples, "+
+			"avg(case when s.dropped or s.server then null else s.rtt end) as rtt, max(s.rtt) as max_rtt, "+
+			"avg(case when s.dropped or s.server then null else s.jitter end) as jitter, "+
+			"avg(case when s.dropped or s.server then null else s.loss end) as loss, max(s.loss) as max_loss, "+
+			"avg(case when s.dropped or s.server then null else s.bitrate end) as bitrate, "+
+			"sum(case when s.quality = ? then 1 else 0 end) as poor, "+
+			"sum(case when s.dropped then 1 else 0 end) as drops, "+
+			"sum(case when s.absent then 1 else 0 end) as absent, "+
+			"sum(case when s.rejoined then 1 else 0 end) as rejoins", CallQualityPoor).
+		Joins("join call_stats s on s.call_id = c.id").
+		Group("c.id, c.chat_id, c.status, c.start, c.ended").
+		Order("c.id desc")
+
+	switch f.Outcome {
+	case "lost":
+		q = q.Where("c.status = ?", CallStatusLost)
+	case "disconnected":
+		q = q.Having("sum(case when s.dropped then 1 else 0 end) > 0")
+	}
+	if f.From != nil {
+		q = q.Where("c.start >= ?", f.From)
+	}
+	if f.To != nil {
+		q = q.Where("c.start < ?", f.To)
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	out := make([]CallQuality, 0)
+	err := q.Scan(&out).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range out {
+		out[i].Reason = CallEndReason(out[i].Status)
+	}
+	return out, nil
+}
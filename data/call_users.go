@@ -1,6 +1,10 @@
 package data
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 type CallUsersDAO struct {
 	db *gorm.DB
@@ -11,6 +15,8 @@ const (
 	CallUserStatusInitiated    = 1
 	CallUserStatusConnecting   = 2
 	CallUserStatusActive       = 3
+	// the user has switched to another call
+	CallUserStatusHeld = 4
 )
 
 type CallUser struct {
@@ -18,6 +24,21 @@ type CallUser struct {
 	UserID   int `gorm:"primaryKey;autoIncrement:false"`
 	DeviceID int
 	Status   int
+	// guests join by the link, they are not members of the chat
+	Guest bool
+	// removed by a moderator, such user can't join the call again
+	Removed bool
+	// the name of the guest, other users are known to clients
+	Name string `gorm:"-"`
+	MediaState
+}
+
+// MediaState is reported by the participant's client, it is cleared when the participant leaves the call
+type MediaState struct {
+	Audio  bool `json:"audio"`
+	Video  bool `json:"video"`
+	Screen bool `json:"screen"`
+	Hand   bool `json:"hand"`
 }
 
 func NewCallUsersDAO(db *gorm.DB) CallUsersDAO {
@@ -36,14 +57,40 @@ func (cu *CallUsersDAO) AddUser(callId, userId, device int, status int) error {
 	return err
 }
 
+func (cu *CallUsersDAO) Add(u *CallUser) error {
+	err := cu.db.Create(u).Error
+	logError(err)
+
+	return err
+}
+
+// AddGuest adds the active guest to the call, guests join media rooms directly, so the session starts at once
+func (cu *CallUsersDAO) AddGuest(callId, userId int) (CallUser, error) {
+	u := CallUser{
+		CallID: callId,
+		UserID: userId,
+		Status: CallUserStatusActive,
+		Guest:  true,
+	}
+	err := cu.Add(&u)
+	if err == nil {
+		err = cu.OpenSession(callId, userId)
+	}
+
+	return u, err
+}
+
 func (cu *CallUsersDAO) UpdateUserDeviceID(callId, userId, device int, status int) error {
 	err := cu.db.
 		Model(&CallUser{}).
 		Where("call_id = ? AND user_id = ?", callId, userId).
-		Updates(map[string]interface{}{
+		Updates(withMediaReset(status, map[string]interface{}{
 			"device_id": device,
 			"status":    status,
-		}).Error
+		})).Error
+	if err == nil {
+		err = cu.logStatus(callId, userId, status)
+	}
 
 	return err
 }
@@ -52,36 +99,194 @@ func (cu *CallUsersDAO) UpdateUserConnState(callId, userId int, status int) erro
 	err := cu.db.
 		Model(&CallUser{}).
 		Where("call_id = ? AND user_id = ?", callId, userId).
-		Updates(map[string]interface{}{
+		Updates(withMediaReset(status, map[string]interface{}{
 			"status": status,
+		})).Error
+	if err == nil {
+		err = cu.logStatus(callId, userId, status)
+	}
+
+	return err
+}
+
+func (cu *CallUsersDAO) SetRemoved(callId, userId int) error {
+	err := cu.db.
+		Model(&CallUser{}).
+		Where("call_id = ? AND user_id = ?", callId, userId).
+		Update("removed", true).Error
+	logError(err)
+
+	return err
+}
+
+func (cu *CallUsersDAO) SetMedia(callId, userId int, state MediaState) error {
+	err := cu.db.
+		Model(&CallUser{}).
+		Where("call_id = ? AND user_id = ?", callId, userId).
+		Updates(map[string]interface{}{
+			"audio":  state.Audio,
+			"video":  state.Video,
+			"screen": state.Screen,
+			"hand":   state.Hand,
 		}).Error
+	logError(err)
 
 	return err
 }
 
+func withMediaReset(status int, fields map[string]interface{}) map[string]interface{} {
+	if status == CallUserStatusDisconnected {
+		for _, name := range []string{"audio", "video", "screen", "hand"} {
+			fields[name] = false
+		}
+	}
+	return fields
+}
+
 func (cu *CallUsersDAO) GetCallUsers(callId int) ([]CallUser, error) {
 	data := []CallUser{}
 	err := cu.db.Where("call_id = ?", callId).Find(&data).Error
+	if err != nil {
+		return data, err
+	}
+
+	err = cu.setGuestNames(data)
 	return data, err
 }
 
+// setGuestNames reads names of guests, they are not in the users list of clients
+func (cu *CallUsersDAO) setGuestNames(users []CallUser) error {
+	ids := make([]int, 0)
+	for _, u := range users {
+		if u.Guest {
+			ids = append(ids, u.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	guests := make([]User, 0, len(ids))
+	err := cu.db.Select("id, name").Where("id IN (?)", ids).Find(&guests).Error
+	logError(err)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[int]string, len(guests))
+	for _, g := range guests {
+		names[int(g.ID)] = g.Name
+	}
+	for i := range users {
+		if users[i].Guest {
+			users[i].Name = names[users[i].UserID]
+		}
+	}
+	return nil
+}
+
 func (cu *CallUsersDAO) GetNotDisconnectedCallUsers(callId int) ([]CallUser, error) {
 	data := []CallUser{}
 	err := cu.db.Where("call_id = ? AND status > ?", callId, CallUserStatusDisconnected).Find(&data).Error
 	return data, err
 }
 
-func (cu *CallUsersDAO) EndCall(callId int) error {
+// GetConnectedGuests returns guests, which are in not ended calls
+func (cu *CallUsersDAO) GetConnectedGuests() ([]CallUser, error) {
+	data := []CallUser{}
 	err := cu.db.
+		Joins("JOIN `calls` ON `calls`.`id` = `call_user`.`call_id` AND `calls`.`status` < 900").
+		Where("`call_user`.`guest` = ? AND `call_user`.`status` > ?", true, CallUserStatusDisconnected).
+		Find(&data).Error
+	logError(err)
+	return data, err
+}
+
+// EndCall disconnects all users, the participation is kept in the call log
+func (cu *CallUsersDAO) EndCall(callId int) error {
+	err := cu.CloseSessions(callId, 0)
+	if err != nil {
+		return err
+	}
+
+	err = cu.db.
 		Model(&CallUser{}).
 		Where("call_id = ?", callId).
-		Updates(map[string]interface{}{
-			"status": 0,
-		}).Error
+		Updates(withMediaReset(CallUserStatusDisconnected, map[string]interface{}{
+			"status": CallUserStatusDisconnected,
+		})).Error
 
 	return err
 }
 
+// logStatus adds the join event when the user becomes active and the leave event when the user is disconnected
+// or holds the call, reconnecting users stay in the call
+func (cu *CallUsersDAO) logStatus(callId, userId, status int) error {
+	switch status {
+	case CallUserStatusActive:
+		return cu.OpenSession(callId, userId)
+	case CallUserStatusDisconnected, CallUserStatusHeld:
+		return cu.CloseSessions(callId, userId)
+	}
+	return nil
+}
+
+// OpenSession adds the join event if the user is not in the call yet
+func (cu *CallUsersDAO) OpenSession(callId, userId int) error {
+	last, err := cu.lastEvent(callId, userId)
+	if err != nil || last.Type == CallEventJoin {
+		return err
+	}
+
+	err = cu.db.Create(&CallEvent{CallID: callId, UserID: userId, Type: CallEventJoin, Date: time.Now()}).Error
+	logError(err)
+	return err
+}
+
+// CloseSessions adds the leave event for the user or for all users of the call if userId is 0
+func (cu *CallUsersDAO) CloseSessions(callId, userId int) error {
+	q := cu.db.Where("call_id = ?", callId)
+	if userId != 0 {
+		q = q.Where("user_id = ?", userId)
+	}
+
+	events := make([]CallEvent, 0)
+	err := q.Order("id").Find(&events).Error
+	logError(err)
+	if err != nil {
+		return err
+	}
+
+	open := make(map[int]bool)
+	for _, e := range events {
+		open[e.UserID] = e.Type == CallEventJoin
+	}
+
+	now := time.Now()
+	for uid, ok := range open {
+		if !ok {
+			continue
+		}
+		err = cu.db.Create(&CallEvent{CallID: callId, UserID: uid, Type: CallEventLeave, Date: now}).Error
+		logError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cu *CallUsersDAO) lastEvent(callId, userId int) (CallEvent, error) {
+	e := CallEvent{}
+	err := cu.db.Where("call_id = ? AND user_id = ?", callId, userId).Order("id desc").Limit(1).Find(&e).Error
+	if gorm.IsRecordNotFoundError(err) {
+		err = nil
+	}
+	logError(err)
+	return e, err
+}
+
 func (cu CallUser) TableName() string {
 	return "call_user"
 }
@@ -18,6 +18,8 @@ type Chat struct {
 	Name        string `json:"name"`
 	LastMessage int    `json:"last"`
 	Avatar      string `json:"avatar"`
+	SlowMode    int    `json:"slow_mode"`
+	Retention   int    `json:"retention"`
 }
 
 func (d *ChatsDAO) GetOne(id int) (*Chat, error) {
@@ -48,6 +50,15 @@ func (d *ChatsDAO) AddDirect(targetUserId int, userId int) (int, error) {
 		return userChat.ChatID, nil
 	}
 
+	if d.dao.Users.IsGuest(targetUserId) {
+		return 0, ErrAccessDenied
+	}
+
+	err = d.dao.Blocks.CanWriteDirect(userId, targetUserId)
+	if err != nil {
+		return 0, err
+	}
+
 	chat := Chat{}
 	err = d.db.Save(&chat).Error
 	logError(err)
@@ -55,26 +66,54 @@ func (d *ChatsDAO) AddDirect(targetUserId int, userId int) (int, error) {
 		return 0, err
 	}
 
-	err = d.setUsersToDB(chat.ID, []int{userId}, targetUserId)
+	err = d.setUsersToDB(chat.ID, []int{userId}, targetUserId, userId)
 	if err == nil {
-		err = d.setUsersToDB(chat.ID, []int{targetUserId}, userId)
+		err = d.setUsersToDB(chat.ID, []int{targetUserId}, userId, userId)
 	}
 
 	return chat.ID, err
 }
 
-func (d *ChatsDAO) AddGroup(name, avatar string, users []int) (int, error) {
+func (d *ChatsDAO) AddGroup(name, avatar string, users []int, by int) (int, error) {
+	err := d.checkNewUsers(0, users, by)
+	if err != nil {
+		return 0, err
+	}
+
 	chat := Chat{Name: name, Avatar: avatar}
-	err := d.db.Save(&chat).Error
+	err = d.db.Save(&chat).Error
 	logError(err)
 	if err != nil {
 		return 0, err
 	}
 
-	return chat.ID, d.setUsersToDB(chat.ID, users, 0)
+	err = d.setUsersToDB(chat.ID, users, 0, by)
+	if err == nil && by != 0 {
+		err = d.SetRole(chat.ID, by, ChatRoleAdmin)
+	}
+
+	return chat.ID, err
 }
 
-func (d *ChatsDAO) SetUsers(chatId int, users []int) (int, error) {
+// Delete removes the chat with its members and messages, it is used for chats which have not been used yet
+func (d *ChatsDAO) Delete(chatId int) error {
+	for _, u := range d.dao.UsersCache.GetUsers(chatId) {
+		d.dao.UsersCache.LeaveChat(u, chatId)
+	}
+
+	err := d.db.Delete(UserChat{}, "chat_id = ?", chatId).Error
+	if err == nil {
+		err = d.db.Delete(Message{}, "chat_id = ?", chatId).Error
+	}
+	if err == nil {
+		err = d.db.Delete(Chat{}, "id = ?", chatId).Error
+	}
+	logError(err)
+
+	return err
+}
+
+func (d *ChatsDAO) SetUsers(chatId int, users []int, by int) (int, error) {
 	uChat := UserChat{}
 	err := d.db.Where("chat_id = ?", chatId).First(&uChat).Error
 	logError(err)
@@ -85,16 +124,19 @@ func (d *ChatsDAO) SetUsers(chatId int, users []int) (int, error) {
 	if uChat.DirectID > 0 {
 		// when adding people to private chate - create new group chat
 		name := d.dao.Users.GetGroupName(users)
-		chatId, err = d.dao.Chats.AddGroup(name, "", users)
+		chatId, err = d.dao.Chats.AddGroup(name, "", users, by)
 	} else {
-		err = d.setUsersToDB(chatId, users, 0)
+		err = d.checkNewUsers(chatId, users, by)
+		if err == nil {
+			err = d.setUsersToDB(chatId, users, 0, by)
+		}
 	}
 
 	return chatId, err
 }
 
 func (d *ChatsDAO) Leave(chatId int, userId int) error {
-	err := d.leaveChat(chatId, userId)
+	err := d.leaveChat(chatId, userId, userId)
 	if err != nil {
 		return err
 	}
@@ -140,7 +182,49 @@ func (d *ChatsDAO) SetLastMessage(chatId int, msg *Message) (*Message, error) {
 	return msg, err
 }
 
-func (d *ChatsDAO) setUsersToDB(chat int, next []int, direct int) error {
+// CheckDirectAccess checks that the user is not blocked in the direct chat
+func (d *ChatsDAO) CheckDirectAccess(chatId, userId int) error {
+	uChat := UserChat{}
+	err := d.db.Where("chat_id = ? AND user_id = ?", chatId, userId).First(&uChat).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		logError(err)
+		return err
+	}
+
+	if uChat.DirectID > 0 && d.dao.Blocks.IsBlockedBetween(userId, uChat.DirectID) {
+		return ErrAccessDenied
+	}
+
+	return nil
+}
+
+// checkNewUsers applies privacy settings of the users who are going to be added to the chat
+func (d *ChatsDAO) checkNewUsers(chat int, users []int, by int) error {
+	if by == 0 {
+		return nil
+	}
+
+	for _, u := range users {
+		if chat != 0 && d.dao.UsersCache.HasChat(u, chat) {
+			continue
+		}
+		if d.dao.Users.IsGuest(u) {
+			return ErrAccessDenied
+		}
+
+		err := d.dao.Blocks.CanAddToGroup(by, u)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *ChatsDAO) setUsersToDB(chat int, next []int, direct int, by int) error {
 	for _, u := range next {
 		if !d.dao.UsersCache.HasChat(u, chat) {
 
@@ -156,6 +240,7 @@ func (d *ChatsDAO) setUsersToDB(chat int, next []int, direct int) error {
 			}
 
 			d.dao.UsersCache.JoinChat(u, chat)
+			d.dao.Audit.Add(AuditRecord{Action: AuditChatUserAdd, ActorID: by, ChatID: chat, TargetID: u}, nil)
 		}
 	}
 
@@ -171,7 +256,7 @@ func (d *ChatsDAO) setUsersToDB(chat int, next []int, direct int) error {
 			}
 
 			if !found {
-				err := d.leaveChat(chat, u)
+				err := d.leaveChat(chat, u, by)
 				if err != nil {
 					return err
 				}
@@ -182,14 +267,17 @@ func (d *ChatsDAO) setUsersToDB(chat int, next []int, direct int) error {
 	return nil
 }
 
-func (d *ChatsDAO) leaveChat(chatId, userId int) error {
-	err := d.db.Delete(UserChat{}, "chat_id = ? AND user_id = ? AND direct_id = 0", chatId, userId).
-		Error
+func (d *ChatsDAO) leaveChat(chatId, userId, by int) error {
+	res := d.db.Delete(UserChat{}, "chat_id = ? AND user_id = ? AND direct_id = 0", chatId, userId)
+	err := res.Error
 	logError(err)
 
 	if err == nil {
 		d.dao.UsersCache.LeaveChat(userId, chatId)
 	}
+	if res.RowsAffected > 0 {
+		d.dao.Audit.Add(AuditRecord{Action: AuditChatUserLeave, ActorID: by, ChatID: chatId, TargetID: userId}, nil)
+	}
 	return err
 }
 
@@ -198,3 +286,80 @@ func (d *ChatsDAO) Update(id int, name string, avatar string) error {
 	logError(err)
 	return err
 }
+
+func (d *ChatsDAO) SetRole(chatId, userId, role int) error {
+	err := d.db.Table("user_chats").
+		Where("chat_id = ? AND user_id = ?", chatId, userId).
+		Update("role", role).Error
+	logError(err)
+
+	return err
+}
+
+// IsAdmin checks the user's role, in chats without admins the earliest member is treated as admin,
+// both members of a direct chat are its admins
+func (d *ChatsDAO) IsAdmin(chatId, userId int) bool {
+	admins := make([]UserChat, 0)
+	err := d.db.Where("chat_id = ? AND role = ?", chatId, ChatRoleAdmin).Find(&admins).Error
+	logError(err)
+	if err != nil {
+		return false
+	}
+
+	if len(admins) == 0 {
+		own := UserChat{}
+		err = d.db.Where("chat_id = ? AND user_id = ?", chatId, userId).First(&own).Error
+		if err != nil {
+			return false
+		}
+		return own.DirectID != 0 || d.Owner(chatId) == userId
+	}
+
+	for _, a := range admins {
+		if a.UserID == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the member who joined the group chat first, new group chats get their creator as admin,
+// so it is used only for older chats without admins
+func (d *ChatsDAO) Owner(chatId int) int {
+	uc := UserChat{}
+	err := d.db.Where("chat_id = ? AND direct_id = 0", chatId).Order("id").First(&uc).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			logError(err)
+		}
+		return 0
+	}
+	return uc.UserID
+}
+
+// SetRetention sets days to keep attachments, 0 means the default period, -1 keeps files forever
+func (d *ChatsDAO) SetRetention(id int, days int) error {
+	if days < -1 {
+		return ErrWrongValue
+	}
+
+	err := d.db.Table("chats").
+		Where("id = ?", id).
+		Update("retention", days).Error
+	logError(err)
+
+	return err
+}
+
+func (d *ChatsDAO) SetSlowMode(id int, seconds int) error {
+	if seconds < 0 {
+		return ErrWrongValue
+	}
+
+	err := d.db.Table("chats").
+		Where("id = ?", id).
+		Update("slow_mode", seconds).Error
+	logError(err)
+
+	return err
+}
@@ -1,32 +1,83 @@
 package data
 
 import (
+	"bytes"
 	"image"
 	"image/color"
 	"image/draw"
 	"io"
-	"io/ioutil"
 	"path"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	gonanoid "github.com/matoous/go-nanoid"
 )
 
-func (d *ChatsDAO) UploadAvatar(file io.Reader, path string, server string) (string, error) {
-	target, err := ioutil.TempFile(path, "*.jpg")
+// AvatarUpload keeps the uploader of the avatar, till it is set to a chat only they can see it
+type AvatarUpload struct {
+	Name    string `gorm:"primary_key"`
+	UserID  int
+	Created time.Time
+}
+
+var likeEscape = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (d *ChatsDAO) UploadAvatar(file io.Reader, server string, userId int) (string, error) {
+	target := bytes.Buffer{}
+	err := getImagePreview(file, 300, 300, &target)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := gonanoid.ID(16)
+	if err != nil {
+		return "", err
+	}
+	name += ".jpg"
+
+	_, err = d.dao.Storage.Save(path.Join("avatars", name), &target)
 	if err != nil {
 		return "", err
 	}
 
-	err = getImagePreview(file, 300, 300, target)
+	err = d.db.Create(&AvatarUpload{Name: name, UserID: userId, Created: time.Now()}).Error
+	logError(err)
 	if err != nil {
 		return "", err
 	}
 
-	url := getAvatarURL(filepath.Base(target.Name()), server)
+	url := getAvatarURL(name, server)
 	return url, nil
 }
 
+// CanSeeAvatar allows avatars of own chats, or the ones uploaded by the user and not used yet
+func (d *ChatsDAO) CanSeeAvatar(name string, userId int) bool {
+	if userId == 0 {
+		return false
+	}
+
+	// names can contain "_", which is a wildcard of LIKE
+	chats := make([]Chat, 0)
+	err := d.db.Where("avatar LIKE ? ESCAPE '!'", "%/avatar/"+likeEscape.Replace(name)).Find(&chats).Error
+	logError(err)
+	if err != nil {
+		return false
+	}
+	if len(chats) == 0 {
+		upload := AvatarUpload{}
+		err = d.db.Where("name = ?", name).First(&upload).Error
+		return err == nil && upload.UserID == userId
+	}
+
+	for _, c := range chats {
+		if d.dao.UsersCache.HasChat(userId, c.ID) {
+			return true
+		}
+	}
+	return false
+}
+
 func getAvatarURL(name, server string) string {
 	return server + path.Join("/api/v1/chat", "0", "avatar", name)
 }
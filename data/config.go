@@ -12,3 +12,4 @@ type FeaturesConfig struct {
 
 var ErrFeatureDisabled = errors.New("feature disabled")
 var ErrAccessDenied = errors.New("access denied")
+var ErrWrongValue = errors.New("wrong value")
@@ -2,6 +2,9 @@ package data
 
 import (
 	"log"
+	"mkozhukh/chat/media"
+	"mkozhukh/chat/scanner"
+	"mkozhukh/chat/storage"
 	"strings"
 
 	remote "github.com/mkozhukh/go-remote"
@@ -37,9 +40,25 @@ type DAO struct {
 	CallUsers CallUsersDAO
 	Files     FilesDAO
 	Reactions ReactionsDAO
+	Blocks    BlocksDAO
+	Audit     AuditDAO
+	Sessions  SessionsDAO
+
+	Attachments AttachmentsDAO
+	Uploads     UploadsDAO
+	Recordings  RecordingsDAO
+	Meetings    MeetingsDAO
+	CallStats   CallStatsDAO
 
 	Hub        *remote.Hub
+	Storage    storage.Storage
+	Media      *media.Pipeline
+	Scanner    scanner.Scanner
 	UsersCache UsersCache
+
+	// checks of uploaded files, they are run by a limited count of workers
+	scans        chan func()
+	scanOversize string
 }
 
 func (d *DAO) GetDB() *gorm.DB {
@@ -64,16 +83,33 @@ func NewDAO(db *gorm.DB, config FeaturesConfig) *DAO {
 	d.CallUsers = NewCallUsersDAO(db)
 	d.Files = NewFilesDAO(&d, db)
 	d.Reactions = NewReactionDAO(&d, db)
+	d.Blocks = NewBlocksDAO(&d, db)
+	d.Audit = NewAuditDAO(db)
+	d.Sessions = NewSessionsDAO(db)
+	d.Attachments = NewAttachmentsDAO(&d, db)
+	d.Uploads = NewUploadsDAO(&d, db)
+	d.Recordings = NewRecordingsDAO(&d, db)
+	d.Meetings = NewMeetingsDAO(&d, db)
+	d.CallStats = NewCallStatsDAO(db)
 
 	d.UsersCache = NewUsersCache(&d)
 
 	d.db.AutoMigrate(&User{})
 	d.db.AutoMigrate(&Message{})
-	d.db.AutoMigrate(&Chat{}, &UserChat{})
+	d.db.AutoMigrate(&Chat{}, &UserChat{}, &AvatarUpload{})
 	d.db.AutoMigrate(&Call{})
-	d.db.AutoMigrate(&CallUser{})
+	d.db.AutoMigrate(&CallUser{}, &CallEvent{})
 	d.db.AutoMigrate(&File{})
 	d.db.AutoMigrate(&Reaction{})
+	d.db.AutoMigrate(&UserBlock{})
+	d.db.AutoMigrate(&AuditRecord{})
+	d.db.AutoMigrate(&Session{})
+	d.db.AutoMigrate(&Attachment{})
+	d.db.AutoMigrate(&Upload{})
+	d.db.AutoMigrate(&Recording{})
+	d.db.AutoMigrate(&Meeting{})
+	d.db.AutoMigrate(&CallStat{})
+	d.db.AutoMigrate(&Migration{})
 
 	return &d
 }
@@ -81,3 +117,19 @@ func NewDAO(db *gorm.DB, config FeaturesConfig) *DAO {
 func (d *DAO) SetHub(r *remote.Hub) {
 	d.Hub = r
 }
+
+func (d *DAO) SetStorage(s storage.Storage) {
+	d.Storage = s
+}
+
+func (d *DAO) SetMedia(p *media.Pipeline) {
+	d.Media = p
+}
+
+func (d *DAO) SetScanner(s scanner.Scanner, cfg scanner.Config) {
+	d.Scanner = s
+	d.scanOversize = cfg.Oversize
+	if s != nil {
+		d.startScans(cfg.Workers)
+	}
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"path"
@@ -11,6 +10,8 @@ import (
 	"strings"
 	"time"
 
+	"mkozhukh/chat/media"
+
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 )
@@ -18,6 +19,8 @@ import (
 type FilesDAO struct {
 	dao *DAO
 	db  *gorm.DB
+
+	quotas QuotasConfig
 }
 
 type File struct {
@@ -32,96 +35,187 @@ func NewFilesDAO(dao *DAO, db *gorm.DB) FilesDAO {
 	return FilesDAO{dao: dao, db: db}
 }
 
-func (d *FilesDAO) PostFile(id, uid int, file io.ReadSeeker, name, path, server string) error {
-	target, err := ioutil.TempFile(path, "*")
+func (d *FilesDAO) PostFile(id, uid int, file io.ReadSeeker, name, server string) error {
+	att, err := d.storeFile(id, uid, file, name, server)
 	if err != nil {
 		return err
 	}
-	defer target.Close()
 
-	tf, size, err := d.copyFile(id, name, path, file, target)
+	msg := Message{
+		Date:        time.Now(),
+		ChatID:      id,
+		UserID:      uid,
+		Type:        AttachedFile,
+		Related:     att.FileID,
+		Attachments: []Attachment{att},
+	}
+	err = d.dao.Messages.SaveAndSend(id, &msg, "", 0)
 	if err != nil {
 		return err
 	}
 
-	url := getFileURL(server, tf.UID, name)
-	sizeStr := strconv.Itoa(int(size))
-	mText := url + "\n" + name + "\n" + sizeStr
+	d.afterStore(msg.Attachments[0], server)
+	return nil
+}
 
-	ext := strings.ToLower(filepath.Ext(name))
-	if ext == ".jpeg" || ext == ".jpg" || ext == ".png" || ext == ".gif" {
-		err = createPreview(file, mText, target.Name())
-		if err != nil {
-			log.Println("can't create preview:" + err.Error())
-		} else {
-			mText = mText + "\n" + getPreviewURL(server, tf.UID, name)
-		}
+// PostDraft stores the file without sending, it can be attached to a message later
+func (d *FilesDAO) PostDraft(id, uid int, file io.ReadSeeker, name, server string) (*Attachment, error) {
+	att, err := d.storeFile(id, uid, file, name, server)
+	if err != nil {
+		return nil, err
 	}
 
-	msg := Message{
-		Text:    mText,
-		Date:    time.Now(),
-		ChatID:  id,
-		UserID:  uid,
-		Type:    AttachedFile,
-		Related: tf.ID,
+	att.UserID = uid
+	err = d.dao.Attachments.AddDraft(&att)
+	if err != nil {
+		return nil, err
 	}
-	return d.dao.Messages.SaveAndSend(id, &msg, "", 0)
+
+	d.afterStore(att, server)
+	return &att, nil
 }
 
-func (d *FilesDAO) PostVoice(id, uid int, file io.ReadSeeker, duration, name, path, server string) error {
-	target, err := ioutil.TempFile(path, "*")
+func (d *FilesDAO) storeFile(id, uid int, file io.ReadSeeker, name, server string) (Attachment, error) {
+	err := d.checkQuota(id, uid, file)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	file = stripMetadata(file)
+
+	tf, size, err := d.copyFile(id, name, file)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	att := newAttachment(&tf, file, size, server)
+	if d.dao.Scanner != nil {
+		att.Status = AttachmentPending
+	}
+
+	return att, nil
+}
+
+func (d *FilesDAO) PostVoice(id, uid int, file io.ReadSeeker, duration, name, server string) error {
+	err := d.checkQuota(id, uid, file)
 	if err != nil {
 		return err
 	}
-	defer target.Close()
 
-	tf, _, err := d.copyFile(id, name, path, file, target)
+	voice, err := d.checkVoice(file)
+	if err != nil {
+		return err
+	}
+	if voice.Path != "" {
+		defer os.Remove(voice.Path)
+
+		opus, err := os.Open(voice.Path)
+		if err != nil {
+			return err
+		}
+		defer opus.Close()
+
+		file = opus
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ".ogg"
+	}
+
+	tf, size, err := d.copyFile(id, name, file)
 	if err != nil {
 		return err
 	}
 
-	url := getFileURL(server, tf.UID, name)
-	mText := url + "\n" + duration
+	att := newAttachment(&tf, file, size, server)
+	att.Duration = voice.Duration
+	att.Waveform = voice.Waveform
+	if att.Duration == 0 {
+		// the format can't be measured without ffmpeg
+		att.Duration, _ = strconv.ParseFloat(duration, 64)
+	}
+	if d.dao.Scanner != nil {
+		att.Status = AttachmentPending
+	}
 
 	msg := Message{
-		Text:    mText,
-		Date:    time.Now(),
-		ChatID:  id,
-		UserID:  uid,
-		Type:    VoiceMessage,
-		Related: tf.ID,
+		Date:        time.Now(),
+		ChatID:      id,
+		UserID:      uid,
+		Type:        VoiceMessage,
+		Related:     tf.ID,
+		Attachments: []Attachment{att},
+	}
+
+	err = d.dao.Messages.SaveAndSend(id, &msg, "", 0)
+	if err != nil {
+		return err
 	}
 
-	return d.dao.Messages.SaveAndSend(id, &msg, "", 0)
+	d.afterStore(msg.Attachments[0], server)
+	return nil
 }
 
-func (d *FilesDAO) copyFile(id int, name, path string, file io.ReadSeeker, target *os.File) (File, int64, error) {
-	size, err := io.Copy(target, file)
+func (d *FilesDAO) copyFile(id int, name string, file io.ReadSeeker) (File, int64, error) {
+	uid := uuid.New().String()
+	key := path.Join("files", uid)
+
+	size, err := d.dao.Storage.Save(key, file)
 	if err != nil {
 		return File{}, 0, err
 	}
 	file.Seek(0, 0)
 
-	tf := File{Name: name, Path: target.Name(), ChatID: id, UID: uuid.New().String()}
+	tf := File{Name: name, Path: key, ChatID: id, UID: uid}
 	err = d.db.Save(&tf).Error
 
 	return tf, size, err
 }
 
-func createPreview(file io.ReadSeeker, mText, name string) error {
-	previewName := name + ".preview"
-	preview, err := os.Create(previewName)
+// Delete removes the file and its preview from the storage
+func (d *FilesDAO) Delete(id int) error {
+	f := File{}
+	err := d.db.Where("id = ?", id).First(&f).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	defer preview.Close()
 
-	err = getImagePreview(file, 300, 300, preview)
+	err = d.dao.Storage.Delete(f.Path)
+	for _, size := range media.PreviewSizes {
+		if err == nil {
+			err = d.dao.Storage.Delete(PreviewKey(f.Path, size))
+		}
+	}
+	if err == nil {
+		err = d.db.Delete(&f).Error
+	}
+	logError(err)
+
+	return err
+}
+
+// NormalizePaths converts full paths of files, stored by older versions, to storage keys
+func (d *FilesDAO) NormalizePaths(dataFolder string) error {
+	files := make([]File, 0)
+	err := d.db.Where("path NOT LIKE ? AND path NOT LIKE ?", "files/%", "quarantine/%").Find(&files).Error
 	if err != nil {
 		return err
 	}
 
+	for _, f := range files {
+		key, err := filepath.Rel(dataFolder, f.Path)
+		if err != nil || strings.HasPrefix(key, "..") {
+			log.Println("can't convert file path: " + f.Path)
+			continue
+		}
+
+		key = filepath.ToSlash(key)
+		err = d.db.Model(&File{}).Where("id = ?", f.ID).Update("path", key).Error
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -131,6 +225,21 @@ func (d *FilesDAO) GetOne(id string) *File {
 	return &f
 }
 
+// IsAttached checks that the file was sent and its message was not deleted, drafts are not available
+func (d *FilesDAO) IsAttached(f *File) bool {
+	var count int
+	err := d.db.Model(&Attachment{}).
+		Where("file_id = ? AND status = ? AND message_id <> 0", f.ID, "").
+		Count(&count).Error
+	logError(err)
+
+	return count > 0
+}
+
+func (d *FilesDAO) CanDownload(f *File, userId int) bool {
+	return f.ID != 0 && d.dao.UsersCache.HasChat(userId, f.ChatID)
+}
+
 func getFileURL(server, uid, name string) string {
 	return server + path.Join("/api/v1/files", uid, name)
 }
@@ -138,3 +247,11 @@ func getFileURL(server, uid, name string) string {
 func getPreviewURL(server, uid, name string) string {
 	return server + path.Join("/api/v1/files", uid, "preview", name)
 }
+
+// PreviewKey returns the storage key of the file's preview, the default one has no size suffix
+func PreviewKey(key string, size int) string {
+	if size == 0 || size == media.DefaultPreview {
+		return key + ".preview"
+	}
+	return key + ".preview." + strconv.Itoa(size)
+}
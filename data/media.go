@@ -0,0 +1,171 @@
+package data
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"mkozhukh/chat/media"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// photos bigger than this are stored as is
+const maxStripSize = 32 << 20
+
+// stripMetadata removes EXIF and similar data from the uploaded image
+func stripMetadata(file io.ReadSeeker) io.ReadSeeker {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	file.Seek(0, io.SeekStart)
+
+	switch http.DetectContentType(head[:n]) {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return file
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	file.Seek(0, io.SeekStart)
+	if err != nil || size > maxStripSize {
+		return file
+	}
+
+	data, err := ioutil.ReadAll(file)
+	file.Seek(0, io.SeekStart)
+	if err != nil {
+		return file
+	}
+
+	return bytes.NewReader(media.StripMetadata(data))
+}
+
+// processMedia creates previews and reads metadata of the file in background
+func (d *FilesDAO) processMedia(a Attachment, server string) {
+	if d.dao.Media == nil {
+		return
+	}
+
+	p := d.dao.Media.Find(a.MimeType, a.Name)
+	if p == nil {
+		return
+	}
+
+	err := d.dao.Media.Run(func() {
+		err := d.processFile(p, a, server)
+		if err != nil {
+			log.Printf("[media] can't process %s: %s", a.UID, err.Error())
+		}
+	})
+	if err != nil {
+		log.Printf("[media] %s is sent without previews: %s", a.UID, err.Error())
+	}
+}
+
+func (d *FilesDAO) processFile(p media.Processor, a Attachment, server string) error {
+	f := File{}
+	err := d.db.Where("id = ?", a.FileID).First(&f).Error
+	if err != nil {
+		return err
+	}
+
+	path, err := d.localCopy(f.Path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	info, err := p.Process(path)
+	if err != nil {
+		return err
+	}
+
+	links := make(PreviewLinks)
+	for size, preview := range info.Previews {
+		_, err = d.dao.Storage.Save(PreviewKey(f.Path, size), bytes.NewReader(preview))
+		if err != nil {
+			return err
+		}
+
+		url := getPreviewURL(server, f.UID, f.Name)
+		if size == media.DefaultPreview {
+			a.Preview = url
+		} else {
+			links[strconv.Itoa(size)] = url + "?size=" + strconv.Itoa(size)
+		}
+	}
+
+	err = d.db.Model(&Attachment{}).Where("id = ?", a.ID).Updates(map[string]interface{}{
+		"width":    info.Width,
+		"height":   info.Height,
+		"duration": info.Duration,
+		"preview":  a.Preview,
+		"previews": links,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return d.sendUpdate(a.ID)
+}
+
+// checkVoice validates the uploaded voice message and measures it
+func (d *FilesDAO) checkVoice(file io.ReadSeeker) (*media.Voice, error) {
+	if d.dao.Media == nil {
+		return &media.Voice{}, nil
+	}
+
+	path, err := tempCopy(file)
+	file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	return d.dao.Media.Voice(path)
+}
+
+// localCopy saves the stored file to a temporary one, as external tools can't read from the storage
+func (d *FilesDAO) localCopy(key string) (string, error) {
+	source, _, err := d.dao.Storage.Open(key)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	return tempCopy(source)
+}
+
+func tempCopy(source io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile("", "chat-media")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	_, err = io.Copy(tmp, source)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// sendUpdate notifies clients about changed attachment of the sent message
+func (d *FilesDAO) sendUpdate(attachmentId int) error {
+	a := Attachment{}
+	err := d.db.Where("id = ?", attachmentId).First(&a).Error
+	if err != nil || a.MessageID == 0 || d.dao.Hub == nil {
+		return err
+	}
+
+	msg, err := d.dao.Messages.GetOne(a.MessageID)
+	if err != nil {
+		return err
+	}
+
+	d.dao.Hub.Publish("messages", MessageEvent{Op: "update", Msg: msg})
+	return nil
+}
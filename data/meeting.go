@@ -0,0 +1,185 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MeetingScheduled = iota + 1
+	MeetingReminded
+	MeetingStarted
+	MeetingCancelled
+	// the server was down during the whole meeting
+	MeetingMissed
+)
+
+type MeetingsDAO struct {
+	dao *DAO
+	db  *gorm.DB
+}
+
+type Meeting struct {
+	ID     int `gorm:"primary_key" json:"id"`
+	ChatID int `gorm:"index" json:"chat_id"`
+	UserID int `json:"user_id"`
+	// plain text, it is escaped when rendered
+	Title string    `json:"title"`
+	Start time.Time `gorm:"index" json:"start"`
+	// in minutes
+	Duration  int `json:"duration"`
+	Status    int `gorm:"index" json:"status"`
+	CallID    int `json:"call_id"`
+	MessageID int `json:"-"`
+}
+
+func NewMeetingsDAO(dao *DAO, db *gorm.DB) MeetingsDAO {
+	return MeetingsDAO{dao, db}
+}
+
+func (m *Meeting) End() time.Time {
+	return m.Start.Add(time.Duration(m.Duration) * time.Minute)
+}
+
+// Add saves the meeting and sends the event message to the chat
+func (d *MeetingsDAO) Add(m *Meeting) error {
+	m.ID = 0
+	m.Status = MeetingScheduled
+	if m.Duration <= 0 || m.Start.IsZero() || m.Title == "" {
+		return ErrWrongValue
+	}
+
+	err := d.db.Create(m).Error
+	logError(err)
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		ChatID:  m.ChatID,
+		UserID:  m.UserID,
+		Date:    time.Now(),
+		Type:    MeetingMessage,
+		Related: m.ID,
+		Text:    m.messageText(),
+		Meeting: m,
+	}
+	err = d.dao.Messages.SaveAndSend(m.ChatID, &msg, "", 0)
+	if err != nil {
+		return err
+	}
+
+	m.MessageID = msg.ID
+	return d.db.Model(m).Update("message_id", msg.ID).Error
+}
+
+func (d *MeetingsDAO) GetOne(id int) (*Meeting, error) {
+	m := Meeting{}
+	err := d.db.Where("id = ?", id).First(&m).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetUpcoming returns scheduled meetings of the chat and the ones in progress
+func (d *MeetingsDAO) GetUpcoming(chatId int, now time.Time) ([]Meeting, error) {
+	out := make([]Meeting, 0)
+	err := d.db.
+		Where("chat_id = ? AND status IN (?)", chatId, []int{MeetingScheduled, MeetingReminded, MeetingStarted}).
+		Order("start").
+		Find(&out).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// the end of the meeting is not stored
+	res := out[:0]
+	for _, m := range out {
+		if m.End().After(now) {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// GetPending returns not started meetings which begin before the time
+func (d *MeetingsDAO) GetPending(before time.Time) ([]Meeting, error) {
+	out := make([]Meeting, 0)
+	err := d.db.
+		Where("status IN (?) AND start <= ?", []int{MeetingScheduled, MeetingReminded}, before).
+		Order("start").
+		Find(&out).Error
+	logError(err)
+	return out, err
+}
+
+// SetStatus saves the status and updates the event message in the chat
+func (d *MeetingsDAO) SetStatus(m *Meeting, status int) error {
+	m.Status = status
+	err := d.db.Save(m).Error
+	logError(err)
+	if err != nil || m.MessageID == 0 {
+		return err
+	}
+
+	msg, err := d.dao.Messages.GetOne(m.MessageID)
+	if err != nil {
+		return err
+	}
+	msg.Text = m.messageText()
+	msg.Meeting = m
+	err = d.dao.Messages.Save(msg)
+	if err == nil && d.dao.Hub != nil {
+		d.dao.Hub.Publish("messages", MessageEvent{Op: "update", Msg: msg})
+	}
+	return err
+}
+
+// GetForMessage returns the meeting of the event message
+func (d *MeetingsDAO) GetForMessage(msgId int) (*Meeting, error) {
+	m := Meeting{}
+	err := d.db.Where("message_id = ?", msgId).Find(&m).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (d *MeetingsDAO) GetAllForChat(chatId int) ([]Meeting, error) {
+	out := make([]Meeting, 0)
+	err := d.db.Where("chat_id = ? AND message_id <> 0", chatId).Find(&out).Error
+	logError(err)
+	return out, err
+}
+
+func (d *MeetingsDAO) SetMeetings(msgs []Message, all []Meeting) {
+	byMessage := make(map[int]*Meeting)
+	for i := range all {
+		byMessage[all[i].MessageID] = &all[i]
+	}
+
+	for i := range msgs {
+		msgs[i].Meeting = byMessage[msgs[i].ID]
+	}
+}
+
+// messageText is the readable content of the event message, clients render the card of the meeting from its data
+func (m *Meeting) messageText() string {
+	text := fmt.Sprintf("Meeting \"%s\" at %s, %d min", SafeHTML(m.Title), m.Start.UTC().Format("2006-01-02 15:04 UTC"), m.Duration)
+	switch m.Status {
+	case MeetingCancelled:
+		text += ", cancelled"
+	case MeetingMissed:
+		text += ", missed"
+	}
+	return text
+}
@@ -11,6 +11,7 @@ const (
 	CallRejectedMessage = 901
 	CallMissedMessage   = 902
 	CallBusyMessage     = 903
+	MeetingMessage      = 904
 	AttachedFile        = 800
 	VoiceMessage        = 801
 	BotMessage          = 700
@@ -42,6 +43,10 @@ type Message struct {
 	Type      int              `json:"type"`
 	Related   int              `json:"-"`
 	Reactions map[string][]int `sql:"-" json:"reactions"`
+
+	Attachments []Attachment `sql:"-" json:"attachments,omitempty"`
+	// data of the meeting event message
+	Meeting *Meeting `sql:"-" json:"meeting,omitempty"`
 }
 
 func (d *MessagesDAO) GetOne(msgID int) (*Message, error) {
@@ -55,6 +60,12 @@ func (d *MessagesDAO) GetOne(msgID int) (*Message, error) {
 	if Features.WithReactions {
 		t.Reactions, err = d.dao.Reactions.GetAllForMessage(msgID)
 	}
+	if err == nil {
+		t.Attachments, err = d.dao.Attachments.GetAllForMessage(msgID)
+	}
+	if err == nil && t.Type == MeetingMessage {
+		t.Meeting, err = d.dao.Meetings.GetForMessage(msgID)
+	}
 
 	return &t, err
 }
@@ -71,6 +82,12 @@ func (d *MessagesDAO) GetLast(chatId int) (*Message, error) {
 		t.Reactions, err = d.dao.Reactions.GetAllForMessage(t.ID)
 		logError(err)
 	}
+	if err == nil {
+		t.Attachments, err = d.dao.Attachments.GetAllForMessage(t.ID)
+	}
+	if err == nil && t.Type == MeetingMessage {
+		t.Meeting, err = d.dao.Meetings.GetForMessage(t.ID)
+	}
 
 	return &t, err
 }
@@ -103,14 +120,29 @@ func (d *MessagesDAO) GetAll(chatID int) ([]Message, error) {
 		d.dao.Reactions.SetReactions(msgs, reactions)
 	}
 
+	attachments, err := d.dao.Attachments.GetAllForChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	d.dao.Attachments.SetAttachments(msgs, attachments)
+
+	meetings, err := d.dao.Meetings.GetAllForChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+	d.dao.Meetings.SetMeetings(msgs, meetings)
+
 	return msgs, err
 }
 
 func (d *MessagesDAO) Save(m *Message) error {
 	err := d.db.Save(&m).Error
 	logError(err)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return d.dao.Attachments.Save(m)
 }
 
 func (d *MessagesDAO) Delete(msgID int) error {
@@ -120,13 +152,21 @@ func (d *MessagesDAO) Delete(msgID int) error {
 	}
 
 	err = d.db.Where("message_id = ?", msgID).Delete(&Reaction{}).Error
+	if err == nil {
+		err = d.dao.Attachments.DeleteForMessage(msgID)
+	}
 
 	logError(err)
 	return err
 }
 
 func (d *MessagesDAO) SaveAndSend(c int, msg *Message, origin string, from int) error {
-	err := d.Save(msg)
+	err := d.dao.Chats.CheckDirectAccess(c, msg.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = d.Save(msg)
 	if err != nil {
 		return err
 	}
@@ -174,7 +214,7 @@ func (d *MessagesDAO) Send(c int, msg *Message, origin string, from int) error {
 		return err
 	}
 
-	if msg.Text != "" {
+	if msg.Text != "" || len(msg.Attachments) > 0 {
 		_, err = d.dao.Chats.SetLastMessage(c, msg)
 		if err != nil {
 			return err
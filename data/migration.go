@@ -0,0 +1,28 @@
+package data
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration marks a one-time conversion of the data as completed, so it is not repeated on each start
+type Migration struct {
+	Name string `gorm:"primary_key"`
+	Date time.Time
+}
+
+func (d *DAO) isMigrated(name string) bool {
+	m := Migration{}
+	err := d.db.Where("name = ?", name).First(&m).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		logError(err)
+	}
+	return err == nil
+}
+
+func (d *DAO) setMigrated(name string) error {
+	err := d.db.Save(&Migration{Name: name, Date: time.Now()}).Error
+	logError(err)
+	return err
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotasConfig limits stored files, sizes are in bytes, zero means no limit
+type QuotasConfig struct {
+	User int64
+	Chat int64
+	// days to keep attachments, can be changed per chat
+	Retention int
+}
+
+// ChatUsage is the size of files, stored in the chat
+type ChatUsage struct {
+	ChatID    int    `json:"chat_id"`
+	Name      string `json:"name"`
+	Files     int    `json:"files"`
+	Size      int64  `json:"size"`
+	Retention int    `json:"retention"`
+}
+
+var getUsageSQL = "select c.id as chat_id, c.name, c.retention, count(a.id) as files, coalesce(sum(a.size), 0) as size " +
+	"from chats c left join files f on f.chat_id = c.id left join attachments a on a.file_id = f.id " +
+	"group by c.id, c.name, c.retention order by size desc"
+
+func (d *FilesDAO) SetQuotas(config QuotasConfig) {
+	d.quotas = config
+}
+
+// checkQuota returns an error if the new file doesn't fit into quotas of the user or the chat
+func (d *FilesDAO) checkQuota(chatId, userId int, file io.Seeker) error {
+	if d.quotas.User == 0 && d.quotas.Chat == 0 {
+		return nil
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return d.checkQuotaSize(chatId, userId, size)
+}
+
+// checkQuotaSize checks the size of the file before it is received
+func (d *FilesDAO) checkQuotaSize(chatId, userId int, size int64) error {
+	if d.quotas.User > 0 && d.UserUsage(userId)+size > d.quotas.User {
+		return ErrQuotaExceeded
+	}
+	if d.quotas.Chat > 0 && d.ChatUsage(chatId)+size > d.quotas.Chat {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// UserUsage returns size of files, sent by the user, including drafts
+func (d *FilesDAO) UserUsage(userId int) int64 {
+	var res struct{ Size int64 }
+	err := d.db.Raw("select coalesce(sum(a.size), 0) as size from attachments a left join messages m on m.id = a.message_id "+
+		"where m.user_id = ? or a.message_id = 0 and a.user_id = ?", userId, userId).
+		Scan(&res).Error
+	logError(err)
+
+	return res.Size
+}
+
+func (d *FilesDAO) ChatUsage(chatId int) int64 {
+	var res struct{ Size int64 }
+	err := d.db.Raw("select coalesce(sum(a.size), 0) as size from attachments a join files f on f.id = a.file_id where f.chat_id = ?", chatId).
+		Scan(&res).Error
+	logError(err)
+
+	return res.Size
+}
+
+// GetUsage returns stored files of all chats, the biggest first
+func (d *FilesDAO) GetUsage() ([]ChatUsage, error) {
+	out := make([]ChatUsage, 0)
+	err := d.db.Raw(getUsageSQL).Scan(&out).Error
+	logError(err)
+
+	return out, err
+}
+
+// ApplyRetention removes attachments older than the retention period of their chats
+func (d *FilesDAO) ApplyRetention(now time.Time) (int, error) {
+	chats := make([]Chat, 0)
+	err := d.db.Select("id, retention").Find(&chats).Error
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, c := range chats {
+		days := c.Retention
+		if days == 0 {
+			days = d.quotas.Retention
+		}
+		if days <= 0 {
+			continue
+		}
+
+		expired := make([]Attachment, 0)
+		err = d.db.Raw("select a.* from attachments a join files f on f.id = a.file_id join messages m on m.id = a.message_id "+
+			"where f.chat_id = ? and m.date < ?", c.ID, now.AddDate(0, 0, -days)).
+			Scan(&expired).Error
+		if err != nil {
+			return count, err
+		}
+
+		for _, a := range expired {
+			err = d.Delete(a.FileID)
+			if err != nil {
+				return count, err
+			}
+			d.replaceWithNotice(a.ID, fmt.Sprintf("File %s was removed by the retention policy", SafeHTML(a.Name)))
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// replaceWithNotice removes the attachment from its message and adds the notice to the text
+func (d *FilesDAO) replaceWithNotice(attachmentId int, notice string) {
+	a := Attachment{}
+	err := d.db.Where("id = ?", attachmentId).First(&a).Error
+	if err == nil {
+		err = d.db.Delete(&a).Error
+	}
+	if err != nil || a.MessageID == 0 {
+		logError(err)
+		return
+	}
+
+	msg, err := d.dao.Messages.GetOne(a.MessageID)
+	if err != nil {
+		return
+	}
+
+	if msg.Text != "" {
+		notice = msg.Text + "\n" + notice
+	}
+	msg.Text = notice
+	if len(msg.Attachments) == 0 {
+		msg.Type = 0
+		msg.Related = 0
+	}
+
+	err = d.dao.Messages.Save(msg)
+	if err == nil && d.dao.Hub != nil {
+		d.dao.Hub.Publish("messages", MessageEvent{Op: "update", Msg: msg})
+	}
+	logError(err)
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	RecordingActive = iota + 1
+	// stopped, the file is not stored yet
+	RecordingStopped
+	RecordingSaved
+	RecordingFailed
+)
+
+type RecordingsDAO struct {
+	dao *DAO
+	db  *gorm.DB
+}
+
+// Recording of the call, made by LiveKit Egress or uploaded by the client of a peer-to-peer call
+type Recording struct {
+	ID       int    `gorm:"primary_key" json:"id"`
+	CallID   int    `gorm:"index" json:"call_id"`
+	UserID   int    `json:"user_id"`
+	EgressID string `json:"-"`
+	// local path of the egress file
+	Path string `json:"-"`
+	// draft with the uploaded file, it is sent when the call ends
+	AttachmentID int        `json:"-"`
+	Status       int        `json:"status"`
+	Started      time.Time  `json:"started"`
+	Stopped      *time.Time `json:"stopped"`
+}
+
+func NewRecordingsDAO(dao *DAO, db *gorm.DB) RecordingsDAO {
+	return RecordingsDAO{dao, db}
+}
+
+func (d *RecordingsDAO) Add(r *Recording) error {
+	r.Status = RecordingActive
+	r.Started = time.Now()
+
+	err := d.db.Create(r).Error
+	logError(err)
+	return err
+}
+
+func (d *RecordingsDAO) Save(r *Recording) error {
+	err := d.db.Save(r).Error
+	logError(err)
+	return err
+}
+
+// GetActive returns the running recording of the call, ID is 0 if there is no such one
+func (d *RecordingsDAO) GetActive(callId int) (Recording, error) {
+	r := Recording{}
+	err := d.db.Where("call_id = ? AND status = ?", callId, RecordingActive).Find(&r).Error
+	if gorm.IsRecordNotFoundError(err) {
+		err = nil
+	}
+	logError(err)
+	return r, err
+}
+
+func (d *RecordingsDAO) GetByCall(callId int) ([]Recording, error) {
+	out := make([]Recording, 0)
+	err := d.db.Where("call_id = ?", callId).Order("id").Find(&out).Error
+	logError(err)
+	return out, err
+}
+
+// GetUnsaved returns recordings, which are active or were stopped but not saved yet
+func (d *RecordingsDAO) GetUnsaved() ([]Recording, error) {
+	out := make([]Recording, 0)
+	err := d.db.Where("status IN (?)", []int{RecordingActive, RecordingStopped}).Order("id").Find(&out).Error
+	logError(err)
+	return out, err
+}
+
+// Stop marks the recording as stopped
+func (d *RecordingsDAO) Stop(r *Recording) error {
+	now := time.Now()
+	r.Stopped = &now
+	r.Status = RecordingStopped
+	return d.Save(r)
+}
+
+// SendDraft sends the uploaded recording to the chat as a file message of its author
+func (d *RecordingsDAO) SendDraft(r *Recording, chatId int) error {
+	att := Attachment{}
+	err := d.db.Where("id = ? AND message_id = 0", r.AttachmentID).Find(&att).Error
+	logError(err)
+	if err != nil {
+		return err
+	}
+
+	msg := Message{
+		Date:        time.Now(),
+		ChatID:      chatId,
+		UserID:      r.UserID,
+		Type:        AttachedFile,
+		Related:     att.FileID,
+		Attachments: []Attachment{att},
+	}
+	err = d.dao.Messages.SaveAndSend(chatId, &msg, "", 0)
+	if err != nil {
+		return err
+	}
+
+	r.Status = RecordingSaved
+	return d.Save(r)
+}
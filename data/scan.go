@@ -0,0 +1,189 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"mkozhukh/chat/scanner"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AttachmentPending marks files, which are not checked by the scanner yet
+const AttachmentPending = "pending"
+
+const scanAttempts = 3
+const scanRetryDelay = 10 * time.Second
+
+// files, which could not be checked, are queued again after the time
+const scanRetryLater = 10 * time.Minute
+
+type scanResult int
+
+const (
+	scanDone scanResult = iota
+	scanClean
+	scanFailed
+)
+
+// startScans runs workers of the queue of files to check
+func (d *DAO) startScans(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d.scans = make(chan func(), 1000)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range d.scans {
+				job()
+			}
+		}()
+	}
+}
+
+// afterStore checks the file for viruses, then creates previews
+func (d *FilesDAO) afterStore(a Attachment, server string) {
+	if a.Status != AttachmentPending {
+		d.processMedia(a, server)
+		return
+	}
+
+	d.queueScan(a, server)
+}
+
+// queueScan adds the file to the queue of checks, when the queue is full the file stays pending and is queued later
+func (d *FilesDAO) queueScan(a Attachment, server string) {
+	select {
+	case d.dao.scans <- func() { d.checkFile(a, server) }:
+	default:
+		time.AfterFunc(scanRetryLater, func() { d.queueScan(a, server) })
+	}
+}
+
+func (d *FilesDAO) checkFile(a Attachment, server string) {
+	switch d.scanFile(a) {
+	case scanClean:
+		d.processMedia(a, server)
+	case scanFailed:
+		// the scanner can be restarted or unavailable for a while
+		time.AfterFunc(scanRetryLater, func() { d.queueScan(a, server) })
+	}
+}
+
+// RescanPending checks files, which were left pending by the restart of the server
+func (d *FilesDAO) RescanPending(server string) error {
+	pending := make([]Attachment, 0)
+	err := d.db.Where("status = ?", AttachmentPending).Order("id").Find(&pending).Error
+	logError(err)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	if d.dao.Scanner == nil {
+		log.Printf("[scanner] %d files are pending, but the scanner is not configured", len(pending))
+		return nil
+	}
+
+	log.Printf("[scanner] %d pending files are queued", len(pending))
+	go func() {
+		for i := range pending {
+			a := pending[i]
+			d.dao.scans <- func() { d.checkFile(a, server) }
+		}
+	}()
+
+	return nil
+}
+
+func (d *FilesDAO) scanFile(a Attachment) scanResult {
+	// the message can be deleted while the file waits for the check
+	err := d.db.Where("id = ? AND status = ?", a.ID, AttachmentPending).First(&Attachment{}).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			logError(err)
+			return scanFailed
+		}
+		return scanDone
+	}
+
+	f := File{}
+	err = d.db.Where("id = ?", a.FileID).First(&f).Error
+	if err != nil {
+		logError(err)
+		return scanDone
+	}
+
+	var res *scanner.Result
+	for i := 0; i < scanAttempts; i++ {
+		if i > 0 {
+			time.Sleep(scanRetryDelay)
+		}
+		res, err = d.scanStored(f.Path)
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		log.Printf("[scanner] %s ( %s ) can't be checked, it stays pending: %s", f.UID, f.Name, err.Error())
+		return scanFailed
+	}
+	if res.Skipped && d.dao.scanOversize == "quarantine" {
+		log.Printf("[scanner] %s ( %s ) is too big to be checked", f.UID, f.Name)
+		d.quarantine(a.ID, &f, "it is too big to be checked")
+		return scanDone
+	}
+	if res.Infected {
+		log.Printf("[scanner] %s ( %s ) is infected: %s", f.UID, f.Name, res.Threat)
+		d.quarantine(a.ID, &f, "a threat was found: "+res.Threat)
+		return scanDone
+	}
+
+	if res.Skipped {
+		log.Printf("[scanner] %s ( %s ) is too big to be checked, it is allowed", f.UID, f.Name)
+	} else {
+		log.Printf("[scanner] %s ( %s ) is clean", f.UID, f.Name)
+	}
+	err = d.db.Model(&Attachment{}).Where("id = ?", a.ID).Update("status", "").Error
+	if err == nil {
+		err = d.sendUpdate(a.ID)
+	}
+	logError(err)
+	if err != nil {
+		return scanFailed
+	}
+
+	return scanClean
+}
+
+func (d *FilesDAO) scanStored(key string) (*scanner.Result, error) {
+	source, _, err := d.dao.Storage.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	return d.dao.Scanner.Scan(source)
+}
+
+// quarantine moves the file out of reach of users and replaces the attachment with a notice
+func (d *FilesDAO) quarantine(attachmentId int, f *File, reason string) {
+	key := "quarantine/" + f.UID
+	source, _, err := d.dao.Storage.Open(f.Path)
+	if err == nil {
+		_, err = d.dao.Storage.Save(key, source)
+		source.Close()
+	}
+	if err == nil {
+		err = d.dao.Storage.Delete(f.Path)
+	}
+	if err == nil {
+		err = d.db.Model(f).Update("path", key).Error
+	}
+	if err != nil {
+		log.Printf("[scanner] can't quarantine %s: %s", f.UID, err.Error())
+	}
+
+	d.replaceWithNotice(attachmentId, fmt.Sprintf("File %s was removed, %s", SafeHTML(f.Name), SafeHTML(reason)))
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type SessionsDAO struct {
+	db *gorm.DB
+
+	// owners of sessions checked by requests, revoked sessions have 0 as the owner
+	mu     *sync.RWMutex
+	owners map[int]int
+}
+
+type Session struct {
+	ID        int       `gorm:"primary_key" json:"id"`
+	UserID    int       `gorm:"index" json:"-"`
+	UserAgent string    `json:"user_agent"`
+	Platform  string    `json:"platform"`
+	LastIP    string    `json:"last_ip"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Revoked   bool      `json:"-"`
+	Current   bool      `sql:"-" json:"current"`
+}
+
+func NewSessionsDAO(db *gorm.DB) SessionsDAO {
+	return SessionsDAO{db: db, mu: &sync.RWMutex{}, owners: make(map[int]int)}
+}
+
+func (d *SessionsDAO) Add(userId int, userAgent, platform, ip string) (*Session, error) {
+	now := time.Now()
+	s := Session{
+		UserID:    userId,
+		UserAgent: userAgent,
+		Platform:  platform,
+		LastIP:    ip,
+		Created:   now,
+		LastSeen:  now,
+	}
+
+	err := d.db.Create(&s).Error
+	logError(err)
+	if err == nil {
+		d.setOwner(s.ID, userId)
+	}
+
+	return &s, err
+}
+
+func (d *SessionsDAO) GetAll(userId int) ([]Session, error) {
+	out := make([]Session, 0)
+	err := d.db.Where("user_id = ? AND revoked = ?", userId, false).Order("last_seen desc").Find(&out).Error
+	logError(err)
+
+	return out, err
+}
+
+// IsActive checks that the session belongs to the user and was not revoked,
+// it is called by each request, so the database is queried only once for a session
+func (d *SessionsDAO) IsActive(id, userId int) bool {
+	d.mu.RLock()
+	owner, ok := d.owners[id]
+	d.mu.RUnlock()
+	if ok {
+		return owner != 0 && owner == userId
+	}
+
+	s := Session{}
+	err := d.db.Where("id = ?", id).First(&s).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		logError(err)
+		return false
+	}
+
+	if s.Revoked {
+		s.UserID = 0
+	}
+	d.setOwner(id, s.UserID)
+
+	return s.UserID != 0 && s.UserID == userId
+}
+
+func (d *SessionsDAO) setOwner(id, userId int) {
+	d.mu.Lock()
+	d.owners[id] = userId
+	d.mu.Unlock()
+}
+
+func (d *SessionsDAO) Touch(id int, ip string) error {
+	err := d.db.Model(&Session{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_ip":   ip,
+			"last_seen": time.Now(),
+		}).Error
+	logError(err)
+
+	return err
+}
+
+func (d *SessionsDAO) Revoke(id, userId int) error {
+	res := d.db.Model(&Session{}).
+		Where("id = ? AND user_id = ?", id, userId).
+		Update("revoked", true)
+	logError(res.Error)
+
+	if res.Error == nil && res.RowsAffected == 0 {
+		return ErrAccessDenied
+	}
+	if res.Error == nil {
+		d.setOwner(id, 0)
+	}
+	return res.Error
+}
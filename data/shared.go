@@ -0,0 +1,225 @@
+package data
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// kinds of shared content
+const (
+	SharedImages    = "images"
+	SharedDocuments = "documents"
+	SharedVoice     = "voice"
+	SharedLinks     = "links"
+)
+
+const sharedPageSize = 50
+
+// SharedItem is a file or a link from a message, ID is the cursor of the next page
+type SharedItem struct {
+	ID        int         `json:"id"`
+	MessageID int         `json:"message_id"`
+	ChatID    int         `json:"chat_id"`
+	UserID    int         `json:"user_id"`
+	Date      time.Time   `json:"date"`
+	File      *Attachment `json:"file,omitempty"`
+	Link      string      `json:"link,omitempty"`
+}
+
+type SharedTotal struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+type SharedPage struct {
+	Items []SharedItem `json:"items"`
+	// cursor of the next page, 0 if there are no more items
+	Next   int                    `json:"next"`
+	Totals map[string]SharedTotal `json:"totals,omitempty"`
+}
+
+// SharedFilter selects content of a chat or shared by a user
+type SharedFilter struct {
+	ChatID int
+	UserID int
+	Kind   string
+	Cursor int
+}
+
+var linkRegexp = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+var sharedKindSQL = "case when m.type = " + strconv.Itoa(VoiceMessage) + " then 'voice' when a.mime_type like 'image/%' or a.mime_type like 'video/%' then 'images' else 'documents' end"
+
+// GetShared returns a page of files or links, totals are added to the first page
+func (d *AttachmentsDAO) GetShared(f SharedFilter) (*SharedPage, error) {
+	var page *SharedPage
+	var err error
+
+	switch f.Kind {
+	case SharedLinks:
+		page, err = d.getSharedLinks(f)
+	case SharedImages, SharedDocuments, SharedVoice:
+		page, err = d.getSharedFiles(f)
+	default:
+		return nil, ErrWrongValue
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Cursor == 0 {
+		page.Totals, err = d.GetSharedTotals(f)
+	}
+	return page, err
+}
+
+func (d *AttachmentsDAO) sharedQuery(f SharedFilter) (string, []interface{}) {
+	where := "a.status = '' and a.message_id <> 0"
+	args := make([]interface{}, 0)
+	if f.ChatID != 0 {
+		where += " and f.chat_id = ?"
+		args = append(args, f.ChatID)
+	}
+	if f.UserID != 0 {
+		where += " and m.user_id = ?"
+		args = append(args, f.UserID)
+	}
+
+	return "from attachments a join files f on f.id = a.file_id join messages m on m.id = a.message_id where " + where, args
+}
+
+func (d *AttachmentsDAO) getSharedFiles(f SharedFilter) (*SharedPage, error) {
+	from, args := d.sharedQuery(f)
+	from += " and " + sharedKindSQL + " = ?"
+	args = append(args, f.Kind)
+	if f.Cursor != 0 {
+		from += " and a.id < ?"
+		args = append(args, f.Cursor)
+	}
+
+	rows := make([]struct {
+		Attachment
+		ChatID int
+		Author int
+		Date   time.Time
+	}, 0)
+	err := d.db.Raw("select a.*, f.chat_id, m.user_id as author, m.date "+from+" order by a.id desc limit ?", append(args, sharedPageSize+1)...).
+		Scan(&rows).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SharedPage{Items: make([]SharedItem, 0, len(rows))}
+	if len(rows) > sharedPageSize {
+		rows = rows[:sharedPageSize]
+		page.Next = rows[sharedPageSize-1].ID
+	}
+	for i := range rows {
+		r := &rows[i]
+		page.Items = append(page.Items, SharedItem{
+			ID:        r.ID,
+			MessageID: r.MessageID,
+			ChatID:    r.ChatID,
+			UserID:    r.Author,
+			Date:      r.Date,
+			File:      &r.Attachment,
+		})
+	}
+
+	return page, nil
+}
+
+func (d *AttachmentsDAO) linksQuery(f SharedFilter) *gorm.DB {
+	q := d.db.Model(&Message{}).Where("type = 0 AND (text LIKE ? OR text LIKE ?)", "%http://%", "%https://%")
+	if f.ChatID != 0 {
+		q = q.Where("chat_id = ?", f.ChatID)
+	}
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	return q
+}
+
+func (d *AttachmentsDAO) getSharedLinks(f SharedFilter) (*SharedPage, error) {
+	q := d.linksQuery(f)
+	if f.Cursor != 0 {
+		q = q.Where("id < ?", f.Cursor)
+	}
+
+	msgs := make([]Message, 0)
+	err := q.Order("id desc").Limit(sharedPageSize + 1).Find(&msgs).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SharedPage{Items: make([]SharedItem, 0, len(msgs))}
+	if len(msgs) > sharedPageSize {
+		msgs = msgs[:sharedPageSize]
+		page.Next = msgs[sharedPageSize-1].ID
+	}
+	for _, m := range msgs {
+		for _, link := range findLinks(m.Text) {
+			page.Items = append(page.Items, SharedItem{
+				ID:        m.ID,
+				MessageID: m.ID,
+				ChatID:    m.ChatID,
+				UserID:    m.UserID,
+				Date:      m.Date,
+				Link:      link,
+			})
+		}
+	}
+
+	return page, nil
+}
+
+func findLinks(text string) []string {
+	links := linkRegexp.FindAllString(text, -1)
+	for i, l := range links {
+		// the text is escaped by SafeHTML
+		if p := strings.Index(l, "&lt;"); p >= 0 {
+			l = l[:p]
+		}
+		links[i] = strings.TrimRight(l, ".,;:!?)")
+	}
+	return links
+}
+
+// GetSharedTotals returns count and size of files by their kind, and count of messages with links
+func (d *AttachmentsDAO) GetSharedTotals(f SharedFilter) (map[string]SharedTotal, error) {
+	from, args := d.sharedQuery(f)
+
+	rows := make([]struct {
+		Kind  string
+		Count int
+		Size  int64
+	}, 0)
+	err := d.db.Raw("select "+sharedKindSQL+" as kind, count(*) as count, sum(a.size) as size "+from+" group by 1", args...).
+		Scan(&rows).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]SharedTotal{
+		SharedImages:    {},
+		SharedDocuments: {},
+		SharedVoice:     {},
+	}
+	for _, r := range rows {
+		totals[r.Kind] = SharedTotal{Count: r.Count, Size: r.Size}
+	}
+
+	links := 0
+	err = d.linksQuery(f).Count(&links).Error
+	logError(err)
+	totals[SharedLinks] = SharedTotal{Count: links}
+
+	return totals, err
+}
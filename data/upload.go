@@ -0,0 +1,337 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+var (
+	ErrUploadLimit    = errors.New("upload limit exceeded")
+	ErrUploadOffset   = errors.New("wrong upload offset")
+	ErrUploadNotReady = errors.New("upload is not complete")
+)
+
+// UploadsConfig limits resumable uploads, sizes are in bytes
+type UploadsConfig struct {
+	// max size of a single chunk
+	ChunkSize int64 `default:"10000000"`
+	// max size of a file
+	MaxSize int64 `default:"2000000000"`
+	// max total size of not finished uploads of a user and to a chat
+	User int64 `default:"4000000000"`
+	Chat int64 `default:"10000000000"`
+}
+
+// UploadsDAO keeps chunks in the storage and locks in the database,
+// so requests of the same upload can be served by any instance
+type UploadsDAO struct {
+	dao *DAO
+	db  *gorm.DB
+
+	config UploadsConfig
+}
+
+type Upload struct {
+	ID      string    `gorm:"primary_key" json:"id"`
+	UserID  int       `gorm:"index" json:"-"`
+	ChatID  int       `gorm:"index" json:"chat_id"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Offset  int64     `gorm:"column:received" json:"offset"`
+	Draft   bool      `json:"draft"`
+	Updated time.Time `json:"-"`
+	// the upload is being changed by a request
+	Locked *time.Time `json:"-"`
+}
+
+// the lock of a failed request or instance expires after the time
+const uploadLockTimeout = 10 * time.Minute
+
+func NewUploadsDAO(dao *DAO, db *gorm.DB) UploadsDAO {
+	return UploadsDAO{
+		dao: dao,
+		db:  db,
+	}
+}
+
+func (d *UploadsDAO) SetConfig(config UploadsConfig) {
+	d.config = config
+}
+
+func (d *UploadsDAO) Add(chatId, userId int, name string, size int64, draft bool) (*Upload, error) {
+	if size <= 0 || d.config.MaxSize > 0 && size > d.config.MaxSize {
+		return nil, ErrUploadLimit
+	}
+	if d.config.User > 0 && d.pendingSize("user_id = ?", userId)+size > d.config.User {
+		return nil, ErrUploadLimit
+	}
+	if d.config.Chat > 0 && d.pendingSize("chat_id = ?", chatId)+size > d.config.Chat {
+		return nil, ErrUploadLimit
+	}
+	// the file must fit into storage quotas, so chunks are not received in vain
+	err := d.dao.Files.checkQuotaSize(chatId, userId, size)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := gonanoid.ID(21)
+	if err != nil {
+		return nil, err
+	}
+
+	u := Upload{
+		ID:      id,
+		UserID:  userId,
+		ChatID:  chatId,
+		Name:    name,
+		Size:    size,
+		Draft:   draft,
+		Updated: time.Now(),
+	}
+	err = d.db.Create(&u).Error
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (d *UploadsDAO) pendingSize(where string, id int) int64 {
+	var res struct{ Total int64 }
+	err := d.db.Model(&Upload{}).Select("sum(size) as total").Where(where, id).Scan(&res).Error
+	logError(err)
+
+	return res.Total
+}
+
+func (d *UploadsDAO) GetOne(id string, userId int) (*Upload, error) {
+	u := Upload{}
+	err := d.db.Where("id = ? AND user_id = ?", id, userId).First(&u).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrAccessDenied
+	}
+	logError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// Append writes the next chunk of the file, offset must be equal to the already received size
+func (d *UploadsDAO) Append(u *Upload, offset int64, chunk io.Reader) error {
+	if !d.lock(u.ID) {
+		return ErrUploadOffset
+	}
+	defer d.unlock(u.ID)
+
+	// the upload could be changed by a parallel request
+	err := d.db.Where("id = ?", u.ID).First(u).Error
+	if err != nil {
+		return err
+	}
+	if offset != u.Offset {
+		return ErrUploadOffset
+	}
+
+	limit := u.Size - u.Offset
+	if d.config.ChunkSize > 0 && d.config.ChunkSize < limit {
+		limit = d.config.ChunkSize
+	}
+
+	// a chunk, which was not confirmed, is overwritten by the next attempt with the same offset
+	key := chunkKey(u.ID, u.Offset)
+	n, err := d.dao.Storage.Save(key, io.LimitReader(chunk, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit || n == 0 {
+		d.dao.Storage.Delete(key)
+		if n == 0 {
+			return nil
+		}
+		return ErrUploadLimit
+	}
+
+	u.Offset += n
+	u.Updated = time.Now()
+	err = d.db.Model(u).Updates(map[string]interface{}{"received": u.Offset, "updated": u.Updated}).Error
+	logError(err)
+
+	return err
+}
+
+// Finish attaches the uploaded file to the chat, or stores it as a draft
+func (d *UploadsDAO) Finish(u *Upload, server string) (*Attachment, error) {
+	if !d.lock(u.ID) {
+		return nil, ErrUploadOffset
+	}
+	defer d.unlock(u.ID)
+
+	err := d.db.Where("id = ?", u.ID).First(u).Error
+	if err != nil {
+		return nil, err
+	}
+	if u.Offset != u.Size {
+		return nil, ErrUploadNotReady
+	}
+
+	f, err := d.assemble(u)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var att *Attachment
+	if u.Draft {
+		att, err = d.dao.Files.PostDraft(u.ChatID, u.UserID, f, u.Name, server)
+	} else {
+		err = d.dao.Files.PostFile(u.ChatID, u.UserID, f, u.Name, server)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return att, d.remove(u)
+}
+
+// assemble joins chunks into a temporary file, which can be checked and stored as a whole
+func (d *UploadsDAO) assemble(u *Upload) (*os.File, error) {
+	keys, err := d.chunks(u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "upload-")
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, key := range keys {
+		if key != chunkKey(u.ID, size) {
+			err = ErrUploadNotReady
+			break
+		}
+
+		var source io.ReadCloser
+		source, _, err = d.dao.Storage.Open(key)
+		if err != nil {
+			break
+		}
+		var n int64
+		n, err = io.Copy(f, source)
+		source.Close()
+		if err != nil {
+			break
+		}
+		size += n
+	}
+	if err == nil && size != u.Size {
+		err = ErrUploadNotReady
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *UploadsDAO) Delete(u *Upload) error {
+	if !d.lock(u.ID) {
+		return ErrUploadOffset
+	}
+	defer d.unlock(u.ID)
+
+	return d.remove(u)
+}
+
+// RemoveExpired deletes uploads which were not changed since the time
+func (d *UploadsDAO) RemoveExpired(before time.Time) (int, error) {
+	uploads := make([]Upload, 0)
+	err := d.db.Where("updated < ?", before).Find(&uploads).Error
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range uploads {
+		err = d.Delete(&uploads[i])
+		if err == ErrUploadOffset {
+			// in progress
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func (d *UploadsDAO) remove(u *Upload) error {
+	keys, err := d.chunks(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = d.dao.Storage.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.db.Delete(u).Error
+	logError(err)
+
+	return err
+}
+
+// chunks returns keys of stored chunks, ordered by their offsets
+func (d *UploadsDAO) chunks(id string) ([]string, error) {
+	keys, err := d.dao.Storage.List(path.Join("chunks", path.Base(id)) + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func chunkKey(id string, offset int64) string {
+	// offsets are padded, so the order of names matches the order of chunks
+	return fmt.Sprintf("chunks/%s/%020d", path.Base(id), offset)
+}
+
+// lock marks the upload as busy, it works for all instances sharing the database
+func (d *UploadsDAO) lock(id string) bool {
+	now := time.Now()
+	res := d.db.Model(&Upload{}).
+		Where("id = ? AND (locked IS NULL OR locked < ?)", id, now.Add(-uploadLockTimeout)).
+		UpdateColumn("locked", now)
+	logError(res.Error)
+
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+func (d *UploadsDAO) unlock(id string) {
+	err := d.db.Model(&Upload{}).Where("id = ?", id).UpdateColumn("locked", gorm.Expr("NULL")).Error
+	logError(err)
+}
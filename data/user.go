@@ -1,10 +1,22 @@
 package data
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 const (
 	StatusOffline int = iota + 1
 	StatusOnline
+	StatusAway
+	StatusDoNotDisturb
+)
+
+const (
+	PrivacyEveryone int = iota
+	PrivacyContacts
+	PrivacyNobody
 )
 
 type UsersDAO struct {
@@ -24,6 +36,25 @@ type User struct {
 	UID    string `json:"-"`
 	Status int    `json:"status"`
 	IsBot  bool   `json:"is_bot"`
+	// temporary identity of the call guest, it can't be added to chats
+	IsGuest         bool       `json:"is_guest"`
+	LastSeen        *time.Time `json:"last_seen,omitempty"`
+	LastSeenPrivacy int        `json:"-"`
+	DirectPrivacy   int        `json:"-"`
+	GroupsPrivacy   int        `json:"-"`
+}
+
+// PresenceRank defines which status wins when a user is connected from several devices
+func PresenceRank(status int) int {
+	switch status {
+	case StatusAway:
+		return 1
+	case StatusOnline:
+		return 2
+	case StatusDoNotDisturb:
+		return 3
+	}
+	return 0
 }
 
 func (d *UsersDAO) GetOne(id int) (*User, error) {
@@ -34,14 +65,70 @@ func (d *UsersDAO) GetOne(id int) (*User, error) {
 	return &t, err
 }
 
+func (d *UsersDAO) AddGuest(name string) (*User, error) {
+	u := User{Name: name, IsGuest: true, Status: StatusOnline}
+	err := d.db.Create(&u).Error
+
+	logError(err)
+	return &u, err
+}
+
+// RemoveGuests deletes temporary users of the ended call, their ids stay in the call log
+func (d *UsersDAO) RemoveGuests(callId int) error {
+	guests := d.db.Table("call_user").Select("user_id").Where("call_id = ? AND guest = ?", callId, true).SubQuery()
+	err := d.db.Where("is_guest = ? AND id IN ?", true, guests).Delete(&User{}).Error
+
+	logError(err)
+	return err
+}
+
+func (d *UsersDAO) IsGuest(id int) bool {
+	count := 0
+	err := d.db.Model(&User{}).Where("id = ? AND is_guest = ?", id, true).Count(&count).Error
+
+	logError(err)
+	return count > 0
+}
+
+// GetAll returns users of the chat, guests of calls are not listed
 func (d *UsersDAO) GetAll() ([]User, error) {
 	t := make([]User, 0)
-	err := d.db.Find(&t).Error
+	err := d.db.Where("is_guest IS NULL OR is_guest = ?", false).Find(&t).Error
 
 	logError(err)
 	return t, err
 }
 
+// GetAllFor returns the users list as it must be visible for the viewer
+func (d *UsersDAO) GetAllFor(viewerId int) ([]User, error) {
+	t, err := d.GetAll()
+	if err != nil {
+		return t, err
+	}
+
+	for i := range t {
+		if !d.CanSeeLastSeen(int(t[i].ID), t[i].LastSeenPrivacy, viewerId) {
+			t[i].LastSeen = nil
+		}
+	}
+
+	return t, nil
+}
+
+func (d *UsersDAO) CanSeeLastSeen(userId, privacy, viewerId int) bool {
+	if userId == viewerId {
+		return true
+	}
+
+	switch privacy {
+	case PrivacyNobody:
+		return false
+	case PrivacyContacts:
+		return d.dao.UsersCache.IsContact(userId, viewerId)
+	}
+	return true
+}
+
 func (d *UsersDAO) GetGroupName(users []int) string {
 	t := make([]User, 0)
 	err := d.db.Find(&t, "id in(?)", users).Error
@@ -58,12 +145,53 @@ func (d *UsersDAO) GetGroupName(users []int) string {
 	return out
 }
 
-func (d *UsersDAO) ChangeOnlineStatus(id int, status int) {
-	u, _ := d.GetOne(id)
+func (d *UsersDAO) ChangeOnlineStatus(id int, status int) (*User, error) {
+	u, err := d.GetOne(id)
 	if u.ID == 0 {
-		return
+		return u, err
+	}
+
+	u.Status = status
+	if status == StatusOffline {
+		now := time.Now()
+		u.LastSeen = &now
 	}
 
-	err := d.db.Model(u).Update("status", status).Error
+	err = d.db.Model(u).Updates(map[string]interface{}{
+		"status":    u.Status,
+		"last_seen": u.LastSeen,
+	}).Error
 	logError(err)
+
+	return u, err
+}
+
+func (d *UsersDAO) SetLastSeenPrivacy(id int, privacy int) error {
+	if privacy < PrivacyEveryone || privacy > PrivacyNobody {
+		return ErrWrongValue
+	}
+
+	err := d.db.Model(&User{}).
+		Where("id = ?", id).
+		Update("last_seen_privacy", privacy).Error
+	logError(err)
+
+	return err
+}
+
+// SetChatPrivacy defines who can start direct chats with the user and add the user to groups
+func (d *UsersDAO) SetChatPrivacy(id int, direct, groups int) error {
+	if direct < PrivacyEveryone || direct > PrivacyContacts || groups < PrivacyEveryone || groups > PrivacyContacts {
+		return ErrWrongValue
+	}
+
+	err := d.db.Model(&User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"direct_privacy": direct,
+			"groups_privacy": groups,
+		}).Error
+	logError(err)
+
+	return err
 }
@@ -21,6 +21,11 @@ const (
 	ChatStatusHidden
 )
 
+const (
+	ChatRoleMember int = iota
+	ChatRoleAdmin
+)
+
 type UserChat struct {
 	ID          int `gorm:"primary_key" json:"id"`
 	ChatID      int `json:"chat_id"`
@@ -28,6 +33,7 @@ type UserChat struct {
 	UnreadCount int `json:"unread_count"`
 	DirectID    int `json:"direct_id"`
 	Status      int `json:"status"`
+	Role        int `json:"role"`
 }
 
 type UserChatDetails struct {
@@ -38,10 +44,11 @@ type UserChatDetails struct {
 	MessageType int        `gorm:"column:messagetype" json:"message_type"`
 	Users       []int      `json:"users"`
 	Avatar      string     `json:"avatar"`
+	SlowMode    int        `json:"slow_mode"`
 }
 
-var getUserChatsSQL = "select chats.id, chats.name, chats.avatar, " +
-	"user_chats.direct_id, user_chats.status, user_chats.unread_count, " +
+var getUserChatsSQL = "select chats.id, chats.name, chats.avatar, chats.slow_mode, " +
+	"user_chats.direct_id, user_chats.status, user_chats.unread_count, user_chats.role, " +
 	"messages.text as message, messages.type as messagetype, messages.date " +
 	"from user_chats " +
 	"inner join chats on user_chats.chat_id = chats.id " +
@@ -49,8 +56,8 @@ var getUserChatsSQL = "select chats.id, chats.name, chats.avatar, " +
 	"where user_chats.user_id = ? " +
 	"order by messages.date desc"
 
-var getUserChatSQL = "select chats.id, chats.name, chats.avatar, " +
-	"user_chats.direct_id, user_chats.status, user_chats.unread_count, " +
+var getUserChatSQL = "select chats.id, chats.name, chats.avatar, chats.slow_mode, " +
+	"user_chats.direct_id, user_chats.status, user_chats.unread_count, user_chats.role, " +
 	"messages.text as message, messages.type as messagetype, messages.date " +
 	"from user_chats " +
 	"inner join chats on user_chats.chat_id = chats.id " +
@@ -58,7 +65,7 @@ var getUserChatSQL = "select chats.id, chats.name, chats.avatar, " +
 	"where user_chats.chat_id = ? AND user_chats.user_id = ? " +
 	"order by messages.date desc"
 
-var getUserChatLeaveSQL = "select chats.id, chats.name, chats.avatar, " +
+var getUserChatLeaveSQL = "select chats.id, chats.name, chats.avatar, chats.slow_mode, " +
 	"messages.text as message, messages.type as messagetype, messages.date " +
 	"from chats " +
 	"left outer join messages on chats.last_message = messages.id " +
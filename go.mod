@@ -14,5 +14,9 @@ require (
 	github.com/matoous/go-nanoid v1.5.0
 	github.com/mkozhukh/go-remote v0.0.0-20210614081926-7e5d2122344e
 	github.com/pascaldekloe/jwt v1.9.0
+	github.com/pion/interceptor v0.1.12
+	github.com/pion/rtcp v1.2.10
+	github.com/pion/webrtc/v3 v3.1.50
 	github.com/unrolled/render v1.0.3
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 )
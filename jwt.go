@@ -17,7 +17,7 @@ func init() {
 	JWTPublicKey = []byte(JWTPrivateKey)[32:]
 }
 
-func createUserToken(id int, device int64) ([]byte, error) {
+func createUserToken(id int, device int) ([]byte, error) {
 	var claims jwt.Claims
 	claims.Subject = "user"
 	claims.Expires = jwt.NewNumericTime(time.Now().Add(8 * time.Hour).Round(time.Second))
@@ -0,0 +1,279 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var ErrNotAudio = errors.New("file is not an audio")
+
+// WaveformBars is the count of peaks in the waveform of a voice message
+const WaveformBars = 64
+
+// Voice describes the checked voice message
+type Voice struct {
+	Duration float64
+	// peaks in the 0-100 range
+	Waveform []int
+	// path of the file transcoded to opus, empty if it was not changed
+	Path string
+}
+
+// Voice checks that the file is an audio and measures it, ffmpeg is used when available
+func (p *Pipeline) Voice(path string) (*Voice, error) {
+	if p.ffmpeg == "" {
+		return readVoice(path)
+	}
+
+	v, err := p.probeVoice(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.transcode {
+		v.Path, err = p.toOpus(path)
+		if err != nil {
+			// the original file is still usable
+			log.Println("[media] can't transcode voice:", err.Error())
+		}
+	}
+
+	return v, nil
+}
+
+func (p *Pipeline) probeVoice(path string) (*Voice, error) {
+	out, err := command(p.timeout, p.ffprobe,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_type:format=duration",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return nil, ErrNotAudio
+	}
+
+	probe := struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+	err = json.Unmarshal(out, &probe)
+	if err != nil || len(probe.Streams) == 0 {
+		return nil, ErrNotAudio
+	}
+
+	v := &Voice{}
+	v.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	// mono 8kHz is enough for the waveform
+	pcm, err := command(p.timeout, p.ffmpeg, "-v", "error", "-i", path, "-vn", "-ac", "1", "-ar", "8000", "-f", "s16le", "-")
+	if err != nil {
+		return nil, ErrNotAudio
+	}
+	v.Waveform = waveform16(pcm, 1)
+	if v.Duration == 0 {
+		v.Duration = float64(len(pcm)/2) / 8000
+	}
+
+	return v, nil
+}
+
+func (p *Pipeline) toOpus(path string) (string, error) {
+	tmp, err := ioutil.TempFile("", "chat-voice-*.ogg")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+
+	_, err = command(p.timeout, p.ffmpeg, "-v", "error", "-y", "-i", path, "-vn", "-ac", "1", "-c:a", "libopus", "-b:a", "32k", tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// readVoice checks the audio without external tools, duration and waveform are available only for some formats
+func readVoice(path string) (*Voice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	f.Seek(0, io.SeekStart)
+
+	switch http.DetectContentType(head[:n]) {
+	case "audio/wave":
+		return readWave(f)
+	case "application/ogg":
+		return readOgg(f)
+	case "audio/mpeg", "audio/aiff", "audio/mp4":
+		return &Voice{}, nil
+	}
+
+	// video containers can't be checked to have only audio without ffmpeg
+
+	return nil, ErrNotAudio
+}
+
+func readWave(f io.Reader) (*Voice, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels, bits, format int
+	var rate int
+	pos := 12
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		body := data[pos+8:]
+		// the size can overflow int on 32-bit platforms
+		if size < 0 || size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, ErrNotAudio
+			}
+			format = int(binary.LittleEndian.Uint16(body))
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = int(binary.LittleEndian.Uint16(body[14:]))
+		case "data":
+			if channels == 0 || rate == 0 || bits == 0 {
+				return nil, ErrNotAudio
+			}
+
+			v := &Voice{}
+			if bytesPerSecond := rate * channels * bits / 8; bytesPerSecond > 0 {
+				v.Duration = float64(size) / float64(bytesPerSecond)
+			}
+			if format == 1 && bits == 16 {
+				v.Waveform = waveform16(body, channels)
+			}
+			return v, nil
+		}
+
+		pos += 8 + size + size%2
+	}
+
+	return nil, ErrNotAudio
+}
+
+// readOgg calculates duration of opus or vorbis stream by the granule position of its last page
+func readOgg(f io.ReadSeeker) (*Voice, error) {
+	head := make([]byte, 64)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	rate := 48000
+	skip := 0
+	if i := bytes.Index(head, []byte("OpusHead")); i >= 0 && i+12 <= len(head) {
+		skip = int(binary.LittleEndian.Uint16(head[i+10:]))
+	} else if i := bytes.Index(head, []byte("\x01vorbis")); i >= 0 && i+16 <= len(head) {
+		rate = int(binary.LittleEndian.Uint32(head[i+12:]))
+	} else {
+		return nil, ErrNotAudio
+	}
+
+	// pages are walked from the start, as "OggS" can be a part of the packet data
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var serial uint32
+	granule := int64(-1)
+	header := make([]byte, 27)
+	table := make([]byte, 255)
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(f, header); err != nil {
+			break
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, ErrNotAudio
+		}
+
+		segments := table[:header[26]]
+		if _, err := io.ReadFull(f, segments); err != nil {
+			break
+		}
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+
+		// only the first stream is measured, -1 marks pages without finished packets
+		if first {
+			serial = binary.LittleEndian.Uint32(header[14:])
+		}
+		if pos := int64(binary.LittleEndian.Uint64(header[6:])); pos >= 0 && binary.LittleEndian.Uint32(header[14:]) == serial {
+			granule = pos
+		}
+
+		if _, err := f.Seek(int64(size), io.SeekCurrent); err != nil {
+			break
+		}
+	}
+
+	if granule < 0 || rate <= 0 {
+		return nil, ErrNotAudio
+	}
+	v := &Voice{}
+	if granule > int64(skip) {
+		v.Duration = float64(granule-int64(skip)) / float64(rate)
+	}
+	return v, nil
+}
+
+// waveform16 returns peaks of signed 16-bit samples
+func waveform16(pcm []byte, channels int) []int {
+	frame := 2 * channels
+	count := len(pcm) / frame
+	if count == 0 {
+		return nil
+	}
+
+	peaks := make([]int, WaveformBars)
+	max := 1
+	for i := 0; i < count; i++ {
+		sample := int(int16(binary.LittleEndian.Uint16(pcm[i*frame:])))
+		if sample < 0 {
+			sample = -sample
+		}
+
+		bar := i * WaveformBars / count
+		if sample > peaks[bar] {
+			peaks[bar] = sample
+		}
+		if sample > max {
+			max = sample
+		}
+	}
+
+	for i := range peaks {
+		peaks[i] = peaks[i] * 100 / max
+	}
+	return peaks
+}
@@ -0,0 +1,129 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func waveFile(format, channels, rate, bits int, samples []byte) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk, uint16(format))
+	binary.LittleEndian.PutUint16(fmtChunk[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:], uint32(rate))
+	binary.LittleEndian.PutUint16(fmtChunk[14:], uint16(bits))
+
+	out := []byte("RIFF\x00\x00\x00\x00WAVE")
+	out = append(out, webpChunk("fmt ", fmtChunk)...)
+	return append(out, webpChunk("data", samples)...)
+}
+
+func oggPage(serial uint32, granule int64, body []byte) []byte {
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:], serial)
+
+	table := make([]byte, 0)
+	for left := len(body); ; left -= 255 {
+		if left < 255 {
+			table = append(table, byte(left))
+			break
+		}
+		table = append(table, 255)
+	}
+	header[26] = byte(len(table))
+
+	return join(header, table, body)
+}
+
+func TestReadWave(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		duration float64
+		err      bool
+	}{
+		{"pcm", waveFile(1, 1, 8000, 16, make([]byte, 16000)), 1, false},
+		{"stereo", waveFile(1, 2, 8000, 16, make([]byte, 16000)), 0.5, false},
+		{"zero bytes per second", waveFile(1, 1, 1, 4, make([]byte, 100)), 0, false},
+		{"no rate", waveFile(1, 1, 0, 16, make([]byte, 100)), 0, true},
+		{"truncated data", waveFile(1, 1, 8000, 16, make([]byte, 16000))[:8044], 0.5, false},
+		{"huge chunk size", append([]byte("RIFF\x00\x00\x00\x00WAVEfmt \xff\xff\xff\xff"), make([]byte, 8)...), 0, true},
+		{"without data", []byte("RIFF\x00\x00\x00\x00WAVE"), 0, true},
+	}
+
+	for _, c := range cases {
+		v, err := readWave(bytes.NewReader(c.data))
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if err == nil && v.Duration != c.duration {
+			t.Errorf("%s: duration %f, want %f", c.name, v.Duration, c.duration)
+		}
+	}
+}
+
+func TestReadOgg(t *testing.T) {
+	opusHead := []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	// packet data, which looks like a page
+	fake := join([]byte("OggS\x00\x00"), make([]byte, 8), []byte{0xff, 0xff, 0xff, 0x7f})
+
+	cases := []struct {
+		name     string
+		data     []byte
+		duration float64
+		err      bool
+	}{
+		{"opus", join(oggPage(1, 0, opusHead), oggPage(1, 48000+312, make([]byte, 300))), 1, false},
+		{"fake page in data", join(oggPage(1, 0, opusHead), oggPage(1, 96000+312, fake)), 2, false},
+		{"unfinished page", join(oggPage(1, 0, opusHead), oggPage(1, 48000+312, nil), oggPage(1, -1, make([]byte, 10))), 1, false},
+		{"other stream", join(oggPage(1, 0, opusHead), oggPage(1, 48000+312, nil), oggPage(2, 960000, nil)), 1, false},
+		{"truncated page", join(oggPage(1, 0, opusHead), oggPage(1, 48000+312, make([]byte, 300))[:100]), 1, false},
+		{"garbage after page", join(oggPage(1, 0, opusHead), []byte("garbage garbage garbage garbage")), 0, true},
+		{"not ogg", []byte("OpusHead"), 0, true},
+	}
+
+	for _, c := range cases {
+		v, err := readOgg(bytes.NewReader(c.data))
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if err == nil && v.Duration != c.duration {
+			t.Errorf("%s: duration %f, want %f", c.name, v.Duration, c.duration)
+		}
+	}
+}
+
+func TestReadVoice(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{"wave", waveFile(1, 1, 8000, 16, make([]byte, 16000)), false},
+		{"mp3", append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), make([]byte, 100)...), false},
+		{"webm", append([]byte("\x1a\x45\xdf\xa3"), make([]byte, 100)...), true},
+		{"mp4", append([]byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2"), make([]byte, 100)...), true},
+		{"text", []byte("not an audio"), true},
+	}
+
+	for _, c := range cases {
+		f, err := ioutil.TempFile("", "voice-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(c.data)
+		f.Close()
+
+		_, err = readVoice(f.Name())
+		os.Remove(f.Name())
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// StripMetadata removes EXIF ( including GPS ), XMP and text metadata from jpeg, png and webp images
+// the orientation of jpeg photos is kept, as the image is shown rotated without it
+func StripMetadata(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNG(data)
+	case len(data) > 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WEBP":
+		return stripWebP(data)
+	}
+	return data
+}
+
+func stripJPEG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			// start of the image data
+			return append(out, data[pos:]...)
+		}
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out = append(out, data[pos:pos+2]...)
+			pos += 2
+			continue
+		}
+
+		// the length includes its own two bytes
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 {
+			return data
+		}
+		end := pos + 2 + length
+		if end > len(data) {
+			return data
+		}
+		segment := data[pos:end]
+		body := segment[4:]
+		pos = end
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(body, []byte("Exif\x00\x00")):
+			if o := exifOrientation(body[6:]); o > 1 {
+				out = append(out, orientationSegment(o)...)
+			}
+		case marker == 0xE1 || marker == 0xED:
+			// XMP and IPTC
+		default:
+			out = append(out, segment...)
+		}
+	}
+
+	return data
+}
+
+func exifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	// the offset can overflow int on 32-bit platforms
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment creates APP1 segment with the only orientation tag
+func orientationSegment(o uint16) []byte {
+	body := []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08")
+	body = append(body, 0x00, 0x01)                      // count of entries
+	body = append(body, 0x01, 0x12, 0x00, 0x03)          // orientation, short
+	body = append(body, 0x00, 0x00, 0x00, 0x01)          // count of values
+	body = append(body, byte(o>>8), byte(o), 0x00, 0x00) // value
+	body = append(body, 0x00, 0x00, 0x00, 0x00)          // next ifd
+
+	out := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(body)+2))
+	return append(out, body...)
+}
+
+func stripPNG(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+
+	pos := 8
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return data
+		}
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end <= pos {
+			return data
+		}
+
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+
+	return out
+}
+
+func stripWebP(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return data
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if size < 0 || end > len(data) || end < pos {
+			return data
+		}
+
+		chunk := data[pos:end]
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			vp8x := append([]byte{}, chunk...)
+			if len(vp8x) > 8 {
+				// clear flags of the removed chunks
+				vp8x[8] &^= 0x08 | 0x04
+			}
+			out = append(out, vp8x...)
+		default:
+			out = append(out, chunk...)
+		}
+		pos = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
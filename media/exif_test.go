@@ -0,0 +1,155 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func jpegSegment(marker byte, body []byte) []byte {
+	out := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(body)+2))
+	return append(out, body...)
+}
+
+func pngChunk(kind string, body []byte) []byte {
+	out := make([]byte, 4, 12+len(body))
+	binary.BigEndian.PutUint32(out, uint32(len(body)))
+	out = append(out, kind...)
+	out = append(out, body...)
+	// crc is not checked
+	return append(out, 0, 0, 0, 0)
+}
+
+func webpChunk(kind string, body []byte) []byte {
+	out := []byte(kind)
+	out = append(out, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	out = append(out, body...)
+	if len(body)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	out := join(append([][]byte{[]byte("RIFF\x00\x00\x00\x00WEBP")}, chunks...)...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+var (
+	soi        = []byte{0xFF, 0xD8}
+	sos        = []byte{0xFF, 0xDA, 0x00, 0x02, 0x11, 0x22}
+	pngSig     = []byte("\x89PNG\r\n\x1a\n")
+	exifBody   = append([]byte("Exif\x00\x00"), orientationSegment(6)[10:]...)
+	jfif       = jpegSegment(0xE0, []byte("JFIF\x00\x01\x02"))
+	pngHeader  = pngChunk("IHDR", make([]byte, 13))
+	pngData    = pngChunk("IDAT", []byte{1, 2, 3})
+	pngEnd     = pngChunk("IEND", nil)
+	webpImage  = webpChunk("VP8 ", []byte{1, 2, 3})
+	webpHeader = webpChunk("VP8X", []byte{0x0C, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+)
+
+func TestStripJPEG(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{"zero length segment", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xDA}, nil},
+		{"length of one", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x01, 0x00, 0x00, 0xFF, 0xDA}, nil},
+		{"truncated segment", join(soi, jfif[:6]), nil},
+		{"no start of scan", join(soi, jfif), nil},
+		{"not a marker", join(soi, []byte{0x00, 0xE0, 0x00, 0x04, 0x00, 0x00}, sos), nil},
+		{"only soi", soi, nil},
+		{"without metadata", join(soi, jfif, sos), join(soi, jfif, sos)},
+		{"xmp is removed", join(soi, jfif, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), sos), join(soi, jfif, sos)},
+		{"iptc is removed", join(soi, jpegSegment(0xED, []byte("Photoshop 3.0\x00")), sos), join(soi, sos)},
+		{"orientation is kept", join(soi, jpegSegment(0xE1, exifBody), sos), join(soi, orientationSegment(6), sos)},
+		{"exif without orientation", join(soi, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\x00\x00")), sos), join(soi, sos)},
+		{"truncated exif", join(soi, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A")), sos), join(soi, sos)},
+		{"exif with ifd outside", join(soi, jpegSegment(0xE1, []byte("Exif\x00\x00II\x2A\x00\xFF\xFF\xFF\xFF\x00\x00")), sos), join(soi, sos)},
+		{"exif with ifd inside the header", join(soi, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x02\x00\x00")), sos), join(soi, sos)},
+		{"exif with too many entries", join(soi, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08\xFF\xFF")), sos), join(soi, sos)},
+	}
+
+	for _, c := range cases {
+		expected := c.expected
+		if expected == nil {
+			expected = c.data
+		}
+		if got := stripJPEG(c.data); !bytes.Equal(got, expected) {
+			t.Errorf("%s:\n got: % X\nwant: % X", c.name, got, expected)
+		}
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	text := pngChunk("tEXt", []byte("Comment\x00secret"))
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{"only signature", pngSig, nil},
+		{"truncated chunk header", join(pngSig, pngHeader[:7]), nil},
+		{"truncated chunk body", join(pngSig, pngHeader[:20]), nil},
+		{"huge length", join(pngSig, []byte{0xFF, 0xFF, 0xFF, 0xF0}, []byte("IHDR"), make([]byte, 8)), nil},
+		{"without metadata", join(pngSig, pngHeader, pngData, pngEnd), join(pngSig, pngHeader, pngData, pngEnd)},
+		{"text chunks are removed", join(pngSig, pngHeader, text, pngChunk("iTXt", []byte("XML")), pngChunk("zTXt", []byte("z")), pngData, pngEnd), join(pngSig, pngHeader, pngData, pngEnd)},
+		{"exif is removed", join(pngSig, pngHeader, pngChunk("eXIf", []byte("MM\x00\x2A")), pngData, pngEnd), join(pngSig, pngHeader, pngData, pngEnd)},
+		{"metadata before a broken chunk", join(pngSig, pngHeader, text, pngData[:10]), nil},
+	}
+
+	for _, c := range cases {
+		expected := c.expected
+		if expected == nil {
+			expected = c.data
+		}
+		if got := stripPNG(c.data); !bytes.Equal(got, expected) {
+			t.Errorf("%s:\n got: % X\nwant: % X", c.name, got, expected)
+		}
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	clearedHeader := append([]byte{}, webpHeader...)
+	clearedHeader[8] = 0
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{"only header", webpFile(), nil},
+		{"truncated chunk header", append(webpFile(), 'V', 'P', '8'), nil},
+		{"truncated chunk body", append(webpFile(), webpImage[:10]...), nil},
+		{"huge size", append(webpFile(), 'V', 'P', '8', ' ', 0xF8, 0xFF, 0xFF, 0xFF), nil},
+		{"without metadata", webpFile(webpImage), webpFile(webpImage)},
+		{"metadata is removed", webpFile(webpHeader, webpImage, webpChunk("EXIF", []byte("MM\x00\x2A")), webpChunk("XMP ", []byte("<x/>"))), webpFile(clearedHeader, webpImage)},
+		{"odd chunk size", webpFile(webpChunk("EXIF", []byte{1, 2, 3}), webpImage), webpFile(webpImage)},
+	}
+
+	for _, c := range cases {
+		expected := c.expected
+		if expected == nil {
+			expected = c.data
+		}
+		if got := stripWebP(append([]byte{}, c.data...)); !bytes.Equal(got, expected) {
+			t.Errorf("%s:\n got: % X\nwant: % X", c.name, got, expected)
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	for _, data := range [][]byte{nil, {0xFF}, soi, []byte("RIFF\x00\x00\x00\x00WEBP"), []byte("GIF89a")} {
+		if got := StripMetadata(data); !bytes.Equal(got, data) {
+			t.Errorf("% X is changed: % X", data, got)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package media
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// heicProcessor converts HEIC photos to jpeg with libheif, the original file is kept as is
+type heicProcessor struct {
+	bin     string
+	timeout time.Duration
+}
+
+func (heicProcessor) Match(mime, name string) bool {
+	return mime == "image/heic" || mime == "image/heif" || hasExt(name, ".heic", ".heif")
+}
+
+func (p heicProcessor) Process(path string) (*Info, error) {
+	dir, err := ioutil.TempDir("", "chat-heic")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "image.jpg")
+	_, err = command(p.timeout, p.bin, path, target)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageProcessor{}.Process(target)
+}
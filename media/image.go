@@ -0,0 +1,87 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+
+	"github.com/disintegration/imaging"
+	// registers the decoder of webp images
+	_ "golang.org/x/image/webp"
+)
+
+type imageProcessor struct{}
+
+func (imageProcessor) Match(mime, name string) bool {
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+func (imageProcessor) Process(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, err := imaging.Decode(f, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return imageInfo(src)
+}
+
+// imageInfo creates previews of all sizes
+func imageInfo(src image.Image) (*Info, error) {
+	size := src.Bounds().Size()
+	info := &Info{
+		Width:    size.X,
+		Height:   size.Y,
+		Previews: make(map[int][]byte),
+	}
+
+	for _, s := range PreviewSizes {
+		var dst image.Image
+		if s == DefaultPreview {
+			dst = squarePreview(src, s)
+		} else if size.X > s || size.Y > s {
+			dst = imaging.Fit(src, s, s, imaging.Lanczos)
+		} else {
+			dst = src
+		}
+
+		buf := bytes.Buffer{}
+		err := imaging.Encode(&buf, dst, imaging.JPEG)
+		if err != nil {
+			return nil, err
+		}
+		info.Previews[s] = buf.Bytes()
+	}
+
+	return info, nil
+}
+
+func squarePreview(src image.Image, side int) image.Image {
+	size := src.Bounds().Size()
+	// do not resize small images
+	if size.X > side || size.Y > side {
+		return imaging.Thumbnail(src, side, side, imaging.Lanczos)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	bg := color.RGBA{255, 255, 255, 255}
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	offset := image.Point{
+		(side - size.X) / 2,
+		(side - size.Y) / 2,
+	}
+	draw.Draw(dst, src.Bounds().Add(offset), src, src.Bounds().Min, draw.Over)
+	return dst
+}
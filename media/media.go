@@ -0,0 +1,146 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PreviewSizes are max sides of previews, DefaultPreview is padded to a square
+var PreviewSizes = []int{120, 300, 1280}
+
+const DefaultPreview = 300
+
+var ErrQueueFull = errors.New("media queue is full")
+
+type Config struct {
+	Workers int `default:"2"`
+	// external tools, used only when they are found on the machine
+	FFmpeg      string `default:"ffmpeg"`
+	FFprobe     string `default:"ffprobe"`
+	HeifConvert string `default:"heif-convert"`
+	// max run time of an external tool in seconds
+	Timeout int `default:"60"`
+	// store voice messages as opus/ogg, requires ffmpeg with libopus
+	TranscodeVoice bool
+}
+
+// Info contains metadata of the file and jpeg previews by their size
+type Info struct {
+	Width    int
+	Height   int
+	Duration float64
+	Previews map[int][]byte
+}
+
+// Processor extracts metadata and previews from a local file
+type Processor interface {
+	Match(mime, name string) bool
+	Process(path string) (*Info, error)
+}
+
+type Pipeline struct {
+	processors []Processor
+	jobs       chan func()
+
+	ffmpeg    string
+	ffprobe   string
+	transcode bool
+	timeout   time.Duration
+}
+
+func New(cfg Config) *Pipeline {
+	p := &Pipeline{jobs: make(chan func(), 1000)}
+
+	p.timeout = time.Duration(cfg.Timeout) * time.Second
+	if p.timeout <= 0 {
+		p.timeout = time.Minute
+	}
+
+	p.Register(imageProcessor{})
+	if bin, ok := lookPath(cfg.HeifConvert); ok {
+		p.Register(heicProcessor{bin: bin, timeout: p.timeout})
+	}
+	ffmpeg, ok := lookPath(cfg.FFmpeg)
+	ffprobe, ok2 := lookPath(cfg.FFprobe)
+	if ok && ok2 {
+		p.ffmpeg = ffmpeg
+		p.ffprobe = ffprobe
+		p.transcode = cfg.TranscodeVoice
+		p.Register(videoProcessor{ffmpeg: ffmpeg, ffprobe: ffprobe, timeout: p.timeout})
+	} else {
+		log.Println("[media] ffmpeg is not found, video previews are disabled")
+	}
+
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *Pipeline) Register(pr Processor) {
+	p.processors = append(p.processors, pr)
+}
+
+// Find returns the processor for the file or nil
+func (p *Pipeline) Find(mime, name string) Processor {
+	for _, pr := range p.processors {
+		if pr.Match(mime, name) {
+			return pr
+		}
+	}
+	return nil
+}
+
+// Run adds the job to the queue of the worker pool, the job is rejected when the queue is full,
+// so the upload is not blocked and waiting jobs don't pile up
+func (p *Pipeline) Run(job func()) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *Pipeline) work() {
+	for job := range p.jobs {
+		job()
+	}
+}
+
+func lookPath(bin string) (string, bool) {
+	if bin == "" {
+		return "", false
+	}
+	path, err := exec.LookPath(bin)
+	return path, err == nil
+}
+
+func hasExt(name string, exts ...string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// command runs the external tool and returns its output, the tool is killed after the timeout,
+// so a crafted file can't block the worker
+func command(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return exec.CommandContext(ctx, name, args...).Output()
+}
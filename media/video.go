@@ -0,0 +1,80 @@
+package media
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoProcessor reads duration and the first frame of the video with ffmpeg
+type videoProcessor struct {
+	ffmpeg  string
+	ffprobe string
+	timeout time.Duration
+}
+
+func (videoProcessor) Match(mime, name string) bool {
+	return strings.HasPrefix(mime, "video/")
+}
+
+type probeResult struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+func (p videoProcessor) Process(path string) (*Info, error) {
+	out, err := command(p.timeout, p.ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := probeResult{}
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	if len(probe.Streams) == 0 {
+		// audio only
+		return info, nil
+	}
+
+	dir, err := ioutil.TempDir("", "chat-video")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	frame := filepath.Join(dir, "frame.jpg")
+	_, err = command(p.timeout, p.ffmpeg, "-v", "error", "-i", path, "-frames:v", "1", "-f", "image2", frame)
+	if err != nil {
+		return nil, err
+	}
+
+	preview, err := imageProcessor{}.Process(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Width = probe.Streams[0].Width
+	info.Height = probe.Streams[0].Height
+	info.Previews = preview.Previews
+	return info, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"mkozhukh/chat/storage"
+)
+
+// migrateStorage copies all files between storages, e.g. "./chat migrate-storage local s3"
+func migrateStorage(args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: chat migrate-storage {local|s3} {local|s3}")
+	}
+
+	from, err := storage.NewByType(args[0], Config.Storage, Config.Server.Data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	to, err := storage.NewByType(args[1], Config.Storage, Config.Server.Data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// all prefixes are copied: files, avatars, quarantined files, chunks of uploads, etc.
+	keys, err := from.List("")
+	if err != nil {
+		log.Fatal("can't list files: ", err)
+	}
+
+	count := 0
+	for _, key := range keys {
+		err = storage.Copy(from, to, key)
+		if err != nil {
+			log.Fatal("can't copy ", key, ": ", err)
+		}
+		count++
+	}
+
+	log.Printf("[storage] %d files copied from %s to %s", count, args[0], args[1])
+}
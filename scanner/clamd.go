@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+const clamdChunk = 64 * 1024
+
+// NewClamd creates a scanner, which streams files to the clamd daemon
+func NewClamd(address string, timeout time.Duration) (Scanner, error) {
+	parts := strings.SplitN(address, ":", 2)
+	if len(parts) != 2 || parts[0] != "unix" && parts[0] != "tcp" {
+		return nil, fmt.Errorf("invalid clamd address: %s", address)
+	}
+
+	return &clamdScanner{network: parts[0], address: parts[1], timeout: timeout}, nil
+}
+
+func (s *clamdScanner) Scan(source io.Reader) (*Result, error) {
+	conn, err := net.DialTimeout(s.network, s.address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := io.ReadFull(source, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			_, werr := conn.Write(buf[:4+n])
+			if werr != nil {
+				// clamd closes the connection after the reply about the size limit
+				if res, err := readClamdReply(conn); err == nil {
+					return res, nil
+				}
+				return nil, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return nil, err
+	}
+
+	return readClamdReply(conn)
+}
+
+func readClamdReply(conn net.Conn) (*Result, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply handles replies like "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{Infected: true, Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasPrefix(reply, "INSTREAM size limit exceeded"):
+		// the file is bigger than StreamMaxLength of clamd
+		return &Result{Skipped: true}, nil
+	}
+
+	return nil, errors.New("clamd: " + reply)
+}
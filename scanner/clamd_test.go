@@ -0,0 +1,28 @@
+package scanner
+
+import "testing"
+
+func TestParseClamdReply(t *testing.T) {
+	cases := []struct {
+		reply   string
+		result  Result
+		invalid bool
+	}{
+		{"stream: OK", Result{}, false},
+		{"stream: Eicar-Signature FOUND", Result{Infected: true, Threat: "Eicar-Signature"}, false},
+		{"INSTREAM size limit exceeded. ERROR", Result{Skipped: true}, false},
+		{"stream: lstat() failed. ERROR", Result{}, true},
+		{"", Result{}, true},
+	}
+
+	for _, c := range cases {
+		res, err := parseClamdReply(c.reply)
+		if (err != nil) != c.invalid {
+			t.Errorf("%q: unexpected error %v", c.reply, err)
+			continue
+		}
+		if err == nil && *res != c.result {
+			t.Errorf("%q: result %+v, want %+v", c.reply, *res, c.result)
+		}
+	}
+}
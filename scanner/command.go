@@ -0,0 +1,62 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type commandScanner struct {
+	args    []string
+	timeout time.Duration
+}
+
+// NewCommand creates a scanner, which runs the command for a temporary copy of the file
+func NewCommand(command string, timeout time.Duration) (Scanner, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("scanner command is not defined")
+	}
+
+	return &commandScanner{args: args, timeout: timeout}, nil
+}
+
+func (s *commandScanner) Scan(source io.Reader) (*Result, error) {
+	tmp, err := ioutil.TempFile("", "chat-scan")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, source)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(s.args))
+	for i, a := range s.args {
+		args[i] = strings.ReplaceAll(a, "{file}", tmp.Name())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err == nil {
+		return &Result{}, nil
+	}
+
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 1 {
+		threat := strings.TrimSpace(strings.ReplaceAll(string(out), tmp.Name()+":", ""))
+		threat = strings.TrimSuffix(threat, " FOUND")
+		return &Result{Infected: true, Threat: threat}, nil
+	}
+
+	return nil, errors.New(strings.TrimSpace(err.Error() + " " + string(out)))
+}
@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Result of the check, Threat contains the name of the found virus
+type Result struct {
+	Infected bool
+	Threat   string
+	// the file was not checked, as it is bigger than the limit of the scanner
+	Skipped bool
+}
+
+// Scanner checks content of uploaded files
+type Scanner interface {
+	Scan(source io.Reader) (*Result, error)
+}
+
+type Config struct {
+	// clamd or command, scanning is disabled when empty
+	Type string
+	// clamd address, like unix:/var/run/clamav/clamd.ctl or tcp:127.0.0.1:3310
+	Address string `default:"unix:/var/run/clamav/clamd.ctl"`
+	// command with {file} placeholder, exit code 1 means infected file
+	Command string `default:"clamscan --no-summary {file}"`
+	// in seconds
+	Timeout int `default:"120"`
+	// files over the stream limit of clamd are allowed without the check or quarantined
+	Oversize string `default:"allow"`
+	// count of files checked at once
+	Workers int `default:"2"`
+}
+
+// New creates a scanner of the configured type, it returns nil when scanning is disabled
+func New(cfg Config) (Scanner, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+
+	if cfg.Oversize != "" && cfg.Oversize != "allow" && cfg.Oversize != "quarantine" {
+		return nil, errors.New("unknown oversize action: " + cfg.Oversize)
+	}
+
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "clamd":
+		return NewClamd(cfg.Address, timeout)
+	case "command":
+		return NewCommand(cfg.Command, timeout)
+	}
+
+	return nil, errors.New("unknown scanner type: " + cfg.Type)
+}
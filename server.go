@@ -1,14 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"mime/multipart"
 	"mkozhukh/chat/api"
 	"mkozhukh/chat/data"
+	"mkozhukh/chat/media"
+	"mkozhukh/chat/scanner"
+	"mkozhukh/chat/service"
+	"mkozhukh/chat/storage"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"time"
@@ -28,14 +40,16 @@ type UploadResponse struct {
 	Value  string `json:"value"`
 }
 
+type DraftResponse struct {
+	UploadResponse
+	Attachment *data.Attachment `json:"attachment,omitempty"`
+}
+
 var format = render.New()
 
 func main() {
 	Config.LoadFromFile("./config.yml")
-	aDir := filepath.Join(Config.Server.Data, "avatars")
-	ensureFolders(aDir)
-	fDir := filepath.Join(Config.Server.Data, "files")
-	ensureFolders(fDir)
+	ensureFolders(Config.Server.Data)
 
 	var conn *gorm.DB
 	var err error
@@ -59,13 +73,58 @@ func main() {
 	//dao := data.NewDAO(conn)
 
 	// File storage
-	err = os.MkdirAll(filepath.Join(Config.Server.Data, "avatars"), 0770)
+	store, err := storage.New(Config.Storage, Config.Server.Data)
+	if err != nil {
+		log.Fatal("Can't init file storage", err)
+	}
+	db.SetStorage(store)
+	db.SetMedia(media.New(Config.Media))
+
+	scan, err := scanner.New(Config.Scanner)
+	if err != nil {
+		log.Fatal("Can't init file scanner", err)
+	}
+	db.SetScanner(scan, Config.Scanner)
+	db.Files.SetQuotas(Config.Quotas)
+
+	err = db.Files.NormalizePaths(Config.Server.Data)
 	if err != nil {
-		log.Fatal("Can't create data folder", err)
+		log.Fatal("Can't update paths of files", err)
 	}
+	err = db.Attachments.MigrateFileMessages()
+	if err != nil {
+		log.Fatal("Can't convert file messages", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate-storage" {
+		migrateStorage(os.Args[2:])
+		return
+	}
+
+	db.Uploads.SetConfig(Config.Uploads)
 
-	rapi := api.BuildAPI(db, Config.Features, Config.Livekit, Config.Bots)
+	rapi, sAll := api.BuildAPI(db, Config.Features, Config.Livekit, Config.SFU, Config.Bots, Config.Limits)
 	db.SetHub(rapi.Events)
+	sAll.FileLinks.SetKey(Config.Server.SigningKey)
+	sAll.FileLinks.SetServer(Config.Server.Public)
+	sAll.ICE.SetConfig(Config.Server.Stun, Config.Turn)
+	sAll.UsersActivity.SetConfig(Config.Presence)
+	sAll.Recordings.SetServer(Config.Server.Public)
+	err = sAll.Recordings.Resume()
+	if err != nil {
+		log.Fatal("Can't resume saving of recordings", err)
+	}
+	sAll.Guests.SetServer(Config.Server.Public)
+
+	// the hub is required to notify about checked files
+	err = db.Files.RescanPending(Config.Server.Public)
+	if err != nil {
+		log.Fatal("Can't queue pending files", err)
+	}
+	sAll.Meetings.Start()
+	sAll.Janitor.Start()
+	sAll.Guests.Start()
+	sAll.CallStats.Start()
 
 	// Router
 	r := chi.NewRouter()
@@ -74,8 +133,9 @@ func main() {
 
 	crs := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "OPTIONS", "HEAD", "PATCH", "DELETE"},
 		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"Location", "Upload-Offset", "Upload-Length"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
@@ -89,10 +149,21 @@ func main() {
 				token = r.URL.Query().Get("token")
 			}
 
+			ip := getClientIP(r)
+			r = r.WithContext(context.WithValue(r.Context(), "ip", ip))
+
 			if token != "" {
 				id, device, err := verifyUserToken([]byte(token))
+				if err == nil && !db.Sessions.IsActive(device, id) {
+					err = errors.New("session is revoked")
+				}
 				if err != nil {
-					log.Println("[token]", err.Error())
+					// the request continues without the user, only records of invalid tokens are limited,
+					// so they can't flood the log
+					if sAll.Limits.TakeIP("token", ip) == nil {
+						log.Println("[token]", err.Error())
+						db.Audit.Add(data.AuditRecord{Action: data.AuditTokenInvalid, IP: ip}, map[string]string{"error": err.Error()})
+					}
 				} else {
 					r = r.WithContext(context.WithValue(context.WithValue(r.Context(), "user_id", id), "device_id", device))
 				}
@@ -101,22 +172,42 @@ func main() {
 		})
 	})
 
-	r.Get("/api/v1", rapi.ServeHTTP)
-	r.Post("/api/v1", rapi.ServeHTTP)
+	r.Get("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		device := getDeviceId(r)
+		if r.URL.Query().Get("ws") != "" && device != 0 {
+			db.Sessions.Touch(device, getClientIP(r))
+			w = &trackedWriter{ResponseWriter: w, device: device, sAll: sAll}
+		}
+		rapi.ServeHTTP(w, r)
+	})
+	r.Post("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		// any call of the api, reading included, keeps the device active
+		if uid := getUserId(r); uid != 0 {
+			sAll.UsersActivity.Touch(uid, getDeviceId(r))
+		}
+		rapi.ServeHTTP(w, r)
+	})
 	r.Get("/api/status", rapi.ServeStatus)
 
 	// DEMO ONLY, imitate login
 	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
 		uid, _ := strconv.Atoi(r.URL.Query().Get("id"))
-		device := newDeviceID()
-		token, err := createUserToken(uid, device)
+		session, err := db.Sessions.Add(uid, r.UserAgent(), r.URL.Query().Get("platform"), getClientIP(r))
+		if err != nil {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := createUserToken(uid, session.ID)
 		if err != nil {
 			log.Println("[token]", err.Error())
+		} else {
+			db.Audit.Add(data.AuditRecord{Action: data.AuditTokenIssue, ActorID: uid, DeviceID: session.ID, IP: getClientIP(r)}, nil)
 		}
 		w.Write(token)
 	})
 
-	r.Post("/api/v1/chat/{chatId}/file", func(w http.ResponseWriter, r *http.Request) {
+	r.With(limitRoute(sAll, "file")).Post("/api/v1/chat/{chatId}/file", func(w http.ResponseWriter, r *http.Request) {
 		if !Config.Features.WithFiles {
 			panic(data.ErrFeatureDisabled)
 		}
@@ -134,7 +225,7 @@ func main() {
 		}
 		defer file.Close()
 
-		err = db.Files.PostFile(cid, uid, file, name.Filename, fDir, Config.Server.Public)
+		err = db.Files.PostFile(cid, uid, file, name.Filename, Config.Server.Public)
 		if err != nil {
 			log.Println("file upload error", err.Error())
 			format.JSON(w, 200, UploadResponse{Status: "error"})
@@ -142,7 +233,96 @@ func main() {
 			format.JSON(w, 200, UploadResponse{Status: "server"})
 		}
 	})
-	r.Post("/api/v1/chat/{chatId}/voice", func(w http.ResponseWriter, r *http.Request) {
+	r.With(limitRoute(sAll, "file")).Post("/api/v1/chat/{chatId}/draft", func(w http.ResponseWriter, r *http.Request) {
+		if !Config.Features.WithFiles {
+			panic(data.ErrFeatureDisabled)
+		}
+
+		uid := getUserId(r)
+		cid := chiIntParam(r, "chatId")
+		if !db.UsersCache.HasChat(uid, cid) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+
+		file, name, err := readFormFile(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			format.JSON(w, 200, DraftResponse{UploadResponse: UploadResponse{Status: "error"}})
+			return
+		}
+		defer file.Close()
+
+		att, err := db.Files.PostDraft(cid, uid, file, name.Filename, Config.Server.Public)
+		if err != nil {
+			log.Println("draft upload error", err.Error())
+			format.JSON(w, 200, DraftResponse{UploadResponse: UploadResponse{Status: "error"}})
+		} else {
+			format.JSON(w, 200, DraftResponse{UploadResponse: UploadResponse{Status: "server", Value: att.UID}, Attachment: att})
+		}
+	})
+	r.With(limitRoute(sAll, "file")).Post("/api/v1/calls/{callId}/recording", func(w http.ResponseWriter, r *http.Request) {
+		if !Config.Features.WithFiles {
+			panic(data.ErrFeatureDisabled)
+		}
+
+		file, name, err := readFormFile(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			format.JSON(w, 200, UploadResponse{Status: "error"})
+			return
+		}
+		defer file.Close()
+
+		ctx := service.CallContext{UserID: getUserId(r), DeviceID: getDeviceId(r)}
+		err = sAll.Recordings.Upload(&ctx, chiIntParam(r, "callId"), file, name.Filename, Config.Server.Public)
+		if err == data.ErrAccessDenied {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println("recording upload error", err.Error())
+			format.JSON(w, 200, UploadResponse{Status: "error"})
+		} else {
+			format.JSON(w, 200, UploadResponse{Status: "server"})
+		}
+	})
+	// guests have no token, the signed link grants access to the call
+	r.Post("/api/v1/calls/{callId}/guest", func(w http.ResponseWriter, r *http.Request) {
+		err := sAll.Limits.TakeIP("guest", getClientIP(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if !sAll.FileLinks.Verify(r.URL.Path, r.URL.Query()) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+
+		res, err := sAll.Guests.Join(chiIntParam(r, "callId"), r.FormValue("name"))
+		if err != nil {
+			code := http.StatusForbidden
+			if err == data.ErrWrongValue {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		format.JSON(w, 200, res)
+	})
+	r.Get("/api/v1/meetings/{meetingId}/calendar.ics", func(w http.ResponseWriter, r *http.Request) {
+		m, err := db.Meetings.GetOne(chiIntParam(r, "meetingId"))
+		if err != nil || !db.UsersCache.HasChat(getUserId(r), m.ChatID) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"meeting-%d.ics\"", m.ID))
+		w.Write([]byte(sAll.Meetings.ICalendar(m, Config.Server.Public)))
+	})
+	r.With(limitRoute(sAll, "voice")).Post("/api/v1/chat/{chatId}/voice", func(w http.ResponseWriter, r *http.Request) {
 		if !Config.Features.WithVoiceMessages {
 			panic(data.ErrFeatureDisabled)
 		}
@@ -163,7 +343,7 @@ func main() {
 		err = r.ParseForm()
 		if err == nil {
 			d := r.PostForm.Get("duration")
-			err = db.Files.PostVoice(cid, uid, file, d, name.Filename, fDir, Config.Server.Public)
+			err = db.Files.PostVoice(cid, uid, file, d, name.Filename, Config.Server.Public)
 		}
 
 		if err != nil {
@@ -185,8 +365,24 @@ func main() {
 			http.Error(w, "", http.StatusNotFound)
 			return
 		}
+		if !sAll.FileLinks.Verify(r.URL.Path, r.URL.Query()) && !db.Files.CanDownload(fInfo, getUserId(r)) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		if !db.Files.IsAttached(fInfo) {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
 
-		http.ServeFile(w, r, fInfo.Path)
+		db.Audit.Add(data.AuditRecord{
+			Action:   data.AuditFileDownload,
+			ActorID:  getUserId(r),
+			DeviceID: getDeviceId(r),
+			IP:       getClientIP(r),
+			ChatID:   fInfo.ChatID,
+			TargetID: fInfo.ID,
+		}, map[string]string{"name": fInfo.Name})
+		serveStorageFile(w, r, store, fInfo.Path, fInfo.Name)
 	})
 
 	r.Get("/api/v1/files/{fileId}/preview/{name}", func(w http.ResponseWriter, r *http.Request) {
@@ -201,11 +397,125 @@ func main() {
 			http.Error(w, "", http.StatusNotFound)
 			return
 		}
+		if !sAll.FileLinks.Verify(r.URL.Path, r.URL.Query()) && !db.Files.CanDownload(fInfo, getUserId(r)) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		if !db.Files.IsAttached(fInfo) {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		serveStorageFile(w, r, store, data.PreviewKey(fInfo.Path, size), "preview.jpg")
+	})
+
+	// resumable uploads
+	r.With(limitRoute(sAll, "upload")).Post("/api/v1/chat/{chatId}/uploads", func(w http.ResponseWriter, r *http.Request) {
+		if !Config.Features.WithFiles {
+			panic(data.ErrFeatureDisabled)
+		}
+
+		uid := getUserId(r)
+		cid := chiIntParam(r, "chatId")
+		if !db.UsersCache.HasChat(uid, cid) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+
+		size, _ := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		name := filepath.Base(r.FormValue("name"))
+		draft := r.FormValue("draft") == "true"
+
+		u, err := db.Uploads.Add(cid, uid, name, size, draft)
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/v1/uploads/"+u.ID)
+		format.JSON(w, http.StatusCreated, u)
+	})
+	r.Head("/api/v1/uploads/{uploadId}", func(w http.ResponseWriter, r *http.Request) {
+		u, err := db.Uploads.GetOne(chi.URLParam(r, "uploadId"), getUserId(r))
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
 
-		http.ServeFile(w, r, fInfo.Path+".preview")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+		w.WriteHeader(http.StatusOK)
 	})
+	r.Get("/api/v1/uploads/{uploadId}", func(w http.ResponseWriter, r *http.Request) {
+		u, err := db.Uploads.GetOne(chi.URLParam(r, "uploadId"), getUserId(r))
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		format.JSON(w, 200, u)
+	})
+	r.With(limitRoute(sAll, "upload")).Patch("/api/v1/uploads/{uploadId}", func(w http.ResponseWriter, r *http.Request) {
+		u, err := db.Uploads.GetOne(chi.URLParam(r, "uploadId"), getUserId(r))
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			http.Error(w, "wrong offset", http.StatusBadRequest)
+			return
+		}
+
+		err = db.Uploads.Append(u, offset, r.Body)
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
 
-	r.Post("/api/v1/chat/{chatId}/avatar", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.With(limitRoute(sAll, "file")).Post("/api/v1/uploads/{uploadId}/finish", func(w http.ResponseWriter, r *http.Request) {
+		uid := getUserId(r)
+		u, err := db.Uploads.GetOne(chi.URLParam(r, "uploadId"), uid)
+		if err == nil && !db.UsersCache.HasChat(uid, u.ChatID) {
+			err = data.ErrAccessDenied
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		att, err := db.Uploads.Finish(u, Config.Server.Public)
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		res := DraftResponse{UploadResponse: UploadResponse{Status: "server"}, Attachment: att}
+		if att != nil {
+			res.Value = att.UID
+		}
+		format.JSON(w, 200, res)
+	})
+	r.Delete("/api/v1/uploads/{uploadId}", func(w http.ResponseWriter, r *http.Request) {
+		u, err := db.Uploads.GetOne(chi.URLParam(r, "uploadId"), getUserId(r))
+		if err == nil {
+			err = db.Uploads.Delete(u)
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.With(limitRoute(sAll, "avatar")).Post("/api/v1/chat/{chatId}/avatar", func(w http.ResponseWriter, r *http.Request) {
 		uid := getUserId(r)
 		if uid == 0 {
 			http.Error(w, "access denied", http.StatusForbidden)
@@ -224,14 +534,121 @@ func main() {
 		}
 		defer file.Close()
 
-		chat, _ := db.Chats.UploadAvatar(file, aDir, Config.Server.Public)
+		chat, _ := db.Chats.UploadAvatar(file, Config.Server.Public, uid)
 		format.JSON(w, 200, UploadResponse{Status: "server", Value: chat})
 	})
 
 	r.Get("/api/v1/chat/{chatId}/avatar/{file_name}", func(w http.ResponseWriter, r *http.Request) {
-		name := chi.URLParam(r, "file_name")
-		filePath := filepath.Join(aDir, name)
-		http.ServeFile(w, r, filePath)
+		name := filepath.Base(chi.URLParam(r, "file_name"))
+		if !sAll.FileLinks.Verify(r.URL.Path, r.URL.Query()) && !db.Chats.CanSeeAvatar(name, getUserId(r)) {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		serveStorageFile(w, r, store, path.Join("avatars", name), name)
+	})
+
+	r.With(adminOnly).Get("/api/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := data.AuditFilter{
+			Action: q.Get("action"),
+			From:   queryTime(r, "from"),
+			To:     queryTime(r, "to"),
+			Limit:  1000,
+		}
+		filter.ActorID, _ = strconv.Atoi(q.Get("actor"))
+		filter.ChatID, _ = strconv.Atoi(q.Get("chat"))
+		if limit, err := strconv.Atoi(q.Get("limit")); err == nil {
+			filter.Limit = limit
+		}
+
+		records, err := db.Audit.Find(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if q.Get("format") != "csv" {
+			format.JSON(w, 200, records)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"audit.csv\"")
+		out := csv.NewWriter(w)
+		out.Write([]string{"id", "date", "action", "actor", "device", "ip", "chat", "target", "payload"})
+		for _, a := range records {
+			out.Write([]string{
+				strconv.Itoa(a.ID),
+				a.Date.Format(time.RFC3339),
+				a.Action,
+				strconv.Itoa(a.ActorID),
+				strconv.Itoa(a.DeviceID),
+				a.IP,
+				strconv.Itoa(a.ChatID),
+				strconv.Itoa(a.TargetID),
+				a.Payload,
+			})
+		}
+		out.Flush()
+	})
+
+	r.With(adminOnly).Get("/api/admin/calls/quality", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := data.CallQualityFilter{
+			Outcome: q.Get("outcome"),
+			From:    queryTime(r, "from"),
+			To:      queryTime(r, "to"),
+			Limit:   1000,
+		}
+		if limit, err := strconv.Atoi(q.Get("limit")); err == nil {
+			filter.Limit = limit
+		}
+
+		calls, err := db.CallStats.Summary(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format.JSON(w, 200, calls)
+	})
+
+	r.With(adminOnly).Get("/api/admin/calls/{callId}/quality", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := db.CallStats.GetByCall(chiIntParam(r, "callId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format.JSON(w, 200, stats)
+	})
+
+	r.With(adminOnly).Get("/api/admin/usage", func(w http.ResponseWriter, r *http.Request) {
+		usage, err := db.Files.GetUsage()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") != "csv" {
+			format.JSON(w, 200, usage)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"usage.csv\"")
+		out := csv.NewWriter(w)
+		out.Write([]string{"chat", "name", "files", "size", "retention"})
+		for _, u := range usage {
+			out.Write([]string{
+				strconv.Itoa(u.ChatID),
+				u.Name,
+				strconv.Itoa(u.Files),
+				strconv.FormatInt(u.Size, 10),
+				strconv.Itoa(u.Retention),
+			})
+		}
+		out.Flush()
 	})
 
 	fmt.Println("Listen at port ", Config.Server.Port)
@@ -239,11 +656,24 @@ func main() {
 	log.Println(err.Error())
 }
 
-var dID = time.Now().Unix()
+// trackedWriter registers hijacked socket connections, so they can be closed on session revoking
+type trackedWriter struct {
+	http.ResponseWriter
+	device int
+	sAll   *service.ServiceAll
+}
+
+func (w *trackedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection doesn't support hijacking")
+	}
 
-func newDeviceID() int64 {
-	dID += 1
-	return dID
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+	return w.sAll.Sessions.Track(w.device, conn), rw, nil
 }
 
 func chiIntParam(r *http.Request, key string) int {
@@ -296,6 +726,97 @@ func getDeviceId(r *http.Request) int {
 	return t
 }
 
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the key is not accepted from the query, urls end up in logs and browser history
+		key := r.Header.Get("Admin-Key")
+		if Config.Server.AdminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(Config.Server.AdminKey)) != 1 {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveStorageFile streams the file from the storage or redirects to its signed url
+func serveStorageFile(w http.ResponseWriter, r *http.Request, store storage.Storage, key, name string) {
+	if Config.Storage.Redirect {
+		url, err := store.SignedURL(key, name, 15*time.Minute)
+		if err == nil && url != "" {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
+
+	file, info, err := store.Open(key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			http.Error(w, "", http.StatusNotFound)
+		} else {
+			log.Println("file download error", err.Error())
+			http.Error(w, "", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer file.Close()
+
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime, rs)
+		return
+	}
+
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	io.Copy(w, file)
+}
+
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func queryTime(r *http.Request, key string) *time.Time {
+	t, err := time.Parse(time.RFC3339, r.URL.Query().Get(key))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func limitRoute(sAll *service.ServiceAll, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := sAll.Limits.Take(name, getUserId(r), getDeviceId(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	switch err {
+	case data.ErrAccessDenied:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case data.ErrUploadLimit, data.ErrQuotaExceeded:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case data.ErrUploadOffset, data.ErrUploadNotReady:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Println("upload error", err.Error())
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
 func readFormFile(w http.ResponseWriter, r *http.Request) (multipart.File, *multipart.FileHeader, error) {
 	var limit = int64(10_000_000)
 	r.Body = http.MaxBytesReader(w, r.Body, limit)
@@ -2,14 +2,34 @@ package service
 
 import (
 	"mkozhukh/chat/data"
+	"sync"
 	"time"
 )
 
+// PresenceConfig sets the time of inactivity after which the user becomes away
+type PresenceConfig struct {
+	AwayTimeout int `default:"300"` // in seconds
+}
+
+type devicePresence struct {
+	Status   int
+	Activity time.Time
+	// the away status was set by inactivity, not by the user
+	Auto bool
+}
+
 type usersActivityService struct {
 	dao *data.DAO
 	all *ServiceAll
 
 	offlineDevices map[int]time.Time
+
+	awayTimeout int // in seconds
+	mu          sync.Mutex
+	// presence holds the status of each connected device for each userId
+	presence map[int]map[int]*devicePresence
+	// status holds the last published status for each userId
+	status map[int]int
 }
 
 func newActivityService(dao *data.DAO, all *ServiceAll) *usersActivityService {
@@ -17,11 +37,20 @@ func newActivityService(dao *data.DAO, all *ServiceAll) *usersActivityService {
 		dao:            dao,
 		offlineDevices: make(map[int]time.Time),
 		all:            all,
+		awayTimeout:    300,
+		presence:       make(map[int]map[int]*devicePresence),
+		status:         make(map[int]int),
 	}
 	go service.runCheckOfflineUsers()
 	return &service
 }
 
+func (s *usersActivityService) SetConfig(cfg PresenceConfig) {
+	if cfg.AwayTimeout > 0 {
+		s.awayTimeout = cfg.AwayTimeout
+	}
+}
+
 func (s *usersActivityService) ChangeOnlineStatus(device int, status int) {
 	if status == data.StatusOnline {
 		delete(s.offlineDevices, device)
@@ -33,6 +62,110 @@ func (s *usersActivityService) ChangeOnlineStatus(device int, status int) {
 	}
 }
 
+// ChangeDevicePresence stores the presence of a single device and publishes the user's status if it changes
+func (s *usersActivityService) ChangeDevicePresence(userId, device, status int) {
+	s.mu.Lock()
+	devices, ok := s.presence[userId]
+	if !ok {
+		devices = make(map[int]*devicePresence)
+		s.presence[userId] = devices
+	}
+
+	if status == data.StatusOffline {
+		delete(devices, device)
+	} else {
+		devices[device] = &devicePresence{Status: status, Activity: time.Now()}
+	}
+	s.mu.Unlock()
+
+	s.refreshUserStatus(userId)
+}
+
+// Touch marks the device as active, returning it from the away status set by inactivity,
+// statuses set by the user are kept
+func (s *usersActivityService) Touch(userId, device int) {
+	s.mu.Lock()
+	p, ok := s.presence[userId][device]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	p.Activity = time.Now()
+	changed := p.Status == data.StatusAway && p.Auto
+	if changed {
+		p.Status = data.StatusOnline
+		p.Auto = false
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.refreshUserStatus(userId)
+	}
+}
+
+func (s *usersActivityService) GetStatus(userId int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status, ok := s.status[userId]; ok {
+		return status
+	}
+	return data.StatusOffline
+}
+
+func (s *usersActivityService) refreshUserStatus(userId int) {
+	s.mu.Lock()
+	status := data.StatusOffline
+	for _, p := range s.presence[userId] {
+		if data.PresenceRank(p.Status) > data.PresenceRank(status) {
+			status = p.Status
+		}
+	}
+	if len(s.presence[userId]) == 0 {
+		delete(s.presence, userId)
+	}
+
+	prev, ok := s.status[userId]
+	if ok && prev == status {
+		s.mu.Unlock()
+		return
+	}
+	if status == data.StatusOffline {
+		delete(s.status, userId)
+	} else {
+		s.status[userId] = status
+	}
+	s.mu.Unlock()
+
+	u, err := s.dao.Users.ChangeOnlineStatus(userId, status)
+	if err != nil {
+		return
+	}
+	s.all.Informer.SendUserStatus(u)
+}
+
+func (s *usersActivityService) checkAwayUsers() {
+	check := time.Now().Add(-time.Duration(s.awayTimeout) * time.Second)
+
+	changed := make([]int, 0)
+	s.mu.Lock()
+	for userId, devices := range s.presence {
+		for _, p := range devices {
+			if p.Status == data.StatusOnline && p.Activity.Before(check) {
+				p.Status = data.StatusAway
+				p.Auto = true
+				changed = append(changed, userId)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, userId := range changed {
+		s.refreshUserStatus(userId)
+	}
+}
+
 func (s *usersActivityService) checkOfflineUsers() {
 	if len(s.offlineDevices) == 0 {
 		return
@@ -69,5 +202,6 @@ func (s *usersActivityService) checkOfflineUsers() {
 func (s *usersActivityService) runCheckOfflineUsers() {
 	for range time.Tick(time.Second * 10) {
 		s.checkOfflineUsers()
+		s.checkAwayUsers()
 	}
 }
@@ -13,22 +13,54 @@ type ServiceAll struct {
 	Informer      *informerService
 	UsersActivity *usersActivityService
 	Livekit       *livekitService
+	SFU           *sfuService
+	ICE           *iceService
 	Bots          *botsService
+	Limits        *limitsService
+	Sessions      *sessionsService
+	FileLinks     *fileLinksService
+	Drafts        *draftsService
+	Janitor       *janitorService
+	Recordings    *recordingsService
+	Meetings      *meetingsService
+	Guests        *guestsService
+	CallStats     *callStatsService
 }
 
-func NewService(dao *data.DAO, hub *remote.Hub, livekitConfig LivekitConfig) *ServiceAll {
+func NewService(dao *data.DAO, hub *remote.Hub, livekitConfig LivekitConfig, sfuConfig SFUConfig, limitsConfig RateLimitConfig) *ServiceAll {
 	s := &ServiceAll{}
 
 	livekit := newLivekitService(livekitConfig)
-	baseCall := newCallService(dao, s, livekit != nil)
+
+	// LiveKit is preferred when both media servers are configured
+	var rooms roomsProvider
+	if livekit != nil {
+		rooms = livekit
+	} else {
+		s.SFU = newSFUService(sfuConfig, s)
+		if s.SFU != nil {
+			rooms = s.SFU
+		}
+	}
+	baseCall := newCallService(dao, s, livekit != nil, rooms)
 
 	s.Livekit = livekit
+	s.ICE = newICEService()
 	s.Calls = &baseCall
 	s.GroupCalls = newGroupCallsService(baseCall)
 	s.PersonalCalls = newPersonalCallService(baseCall)
 	s.UsersActivity = newActivityService(dao, s)
-	s.Informer = newInformerService(dao, hub)
+	s.Informer = newInformerService(dao, s, hub)
 	s.Bots = newBotsService(dao)
+	s.Limits = newLimitsService(dao, limitsConfig)
+	s.Sessions = newSessionsService(dao, s)
+	s.FileLinks = newFileLinksService()
+	s.Drafts = newDraftsService(dao)
+	s.Janitor = newJanitorService(dao, s)
+	s.Recordings = newRecordingsService(dao, s)
+	s.Meetings = newMeetingsService(dao, s)
+	s.Guests = newGuestsService(dao, s)
+	s.CallStats = newCallStatsService(dao, s)
 
 	CallProvider = &CallServiceProvider{
 		group:    s.GroupCalls,
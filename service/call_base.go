@@ -10,9 +10,19 @@ import (
 	gonanoid "github.com/matoous/go-nanoid"
 )
 
+// roomsProvider is a media server of group calls
+type roomsProvider interface {
+	CreateRoom(name string) (string, error)
+	DeleteRoom(name string) error
+	DisconnectParticipant(roomName, userId string) error
+	// kind is audio or video
+	MuteParticipant(roomName, userId, kind string, muted bool) error
+}
+
 type baseCallService struct {
-	dao *data.DAO
-	all *ServiceAll
+	dao   *data.DAO
+	all   *ServiceAll
+	rooms roomsProvider
 
 	LivekitEnabled      bool
 	notAcceptedTimeout  int // in seconds
@@ -21,10 +31,11 @@ type baseCallService struct {
 	reconnectingUsers map[int]int64
 }
 
-func newCallService(dao *data.DAO, allService *ServiceAll, withLivekit bool) baseCallService {
+func newCallService(dao *data.DAO, allService *ServiceAll, withLivekit bool, rooms roomsProvider) baseCallService {
 	return baseCallService{
 		dao:                 dao,
 		all:                 allService,
+		rooms:               rooms,
 		LivekitEnabled:      withLivekit,
 		notAcceptedTimeout:  30,
 		ReconnectingTimeout: 30,
@@ -52,6 +63,7 @@ func (s *baseCallService) StartReconnectingTimer(ctx *CallContext, callId int) {
 
 		u := call.GetByUserID(ctx.UserID)
 		if u != nil && u.Status == data.CallUserStatusConnecting {
+			s.all.CallStats.Dropped(call.ID, ctx.UserID)
 			// drop call if the user's reconnecting timed out
 			callService, _ := CallProvider.GetService(call.IsGroupCall)
 			callService.Disconnect(ctx, &call, data.CallStatusDisconnected)
@@ -84,7 +96,16 @@ func (s *baseCallService) CreateJoinToken(ctx *CallContext, callId int) (string,
 		return "", err
 	}
 
-	token, err := s.all.Livekit.CreateJoinToken(call.RoomName, fmt.Sprintf("%d", ctx.UserID))
+	// the same checks as for joining the call
+	cu := call.GetByUserID(ctx.UserID)
+	if cu != nil && cu.Removed {
+		return "", errRemovedFromCall
+	}
+	if call.Locked && (cu == nil || cu.Status != data.CallUserStatusActive && cu.Status != data.CallUserStatusConnecting) {
+		return "", errCallLocked
+	}
+
+	token, err := s.all.Livekit.CreateJoinToken(call.RoomName, fmt.Sprintf("%d", ctx.UserID), "")
 
 	return token, err
 }
@@ -102,8 +123,8 @@ func (s *baseCallService) DropAllCalls(status int) error {
 	}
 
 	for i := range calls {
-		if s.LivekitEnabled && calls[i].RoomName != "" {
-			go s.all.Livekit.DeleteRoom(calls[i].RoomName)
+		if s.rooms != nil && calls[i].RoomName != "" {
+			go s.rooms.DeleteRoom(calls[i].RoomName)
 		}
 
 		msg := data.Message{
@@ -112,6 +133,8 @@ func (s *baseCallService) DropAllCalls(status int) error {
 		}
 
 		s.setEndCallInfo(&calls[i], &msg)
+		s.auditCallEnd(&calls[i], status)
+		s.all.Recordings.Finish(&calls[i])
 		s.all.Informer.SendSignalToCall(&calls[i], status)
 		s.all.Informer.SendMessageEvent(calls[i].ChatID, &msg, "", 0, true)
 	}
@@ -138,6 +161,64 @@ func (s *baseCallService) SetReconnectingStaus(ctx *CallContext) error {
 	return err
 }
 
+// Hold puts the call on hold or resumes it, the resumed call holds other calls of the user
+func (s *baseCallService) Hold(ctx *CallContext, call *data.Call, hold bool) error {
+	cu := call.GetByUserID(ctx.UserID)
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected || call.Status != data.CallStatusActive {
+		return data.ErrAccessDenied
+	}
+	if hold == (cu.Status == data.CallUserStatusHeld) {
+		return nil
+	}
+
+	if !hold {
+		err := s.holdOtherCalls(ctx, call.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.setHold(call, ctx.UserID, hold)
+}
+
+func (s *baseCallService) holdOtherCalls(ctx *CallContext, callId int) error {
+	calls, err := s.dao.Calls.GetAllByUser(ctx.UserID)
+	if err != nil {
+		return err
+	}
+
+	for i := range calls {
+		c := &calls[i]
+		cu := c.GetByUserID(ctx.UserID)
+		if c.ID == callId || c.Status != data.CallStatusActive || cu == nil || cu.Status == data.CallUserStatusHeld {
+			continue
+		}
+
+		err = s.setHold(c, ctx.UserID, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *baseCallService) setHold(c *data.Call, userId int, hold bool) error {
+	status := data.CallUserStatusActive
+	if hold {
+		status = data.CallUserStatusHeld
+	}
+
+	err := s.dao.CallUsers.UpdateUserConnState(c.ID, userId, status)
+	if err != nil {
+		return err
+	}
+	c.GetByUserID(userId).Status = status
+
+	s.all.Informer.SendSignalToParticipants(c, HoldSignal, HoldEvent{CallID: c.ID, UserID: userId, Hold: hold})
+	return nil
+}
+
 func (s *baseCallService) checkForActiveCall(ctx *CallContext, targetChatId int, targetUserId int) error {
 	// check if the current user is already in call
 	call, err := s.dao.Calls.GetByUser(ctx.UserID)
@@ -215,7 +296,7 @@ func (s *baseCallService) updateStatusAndSendMessage(call *data.Call, status int
 }
 
 func (s *baseCallService) createRoom(c *data.Call) error {
-	if !s.LivekitEnabled {
+	if s.rooms == nil {
 		return data.ErrFeatureDisabled
 	}
 
@@ -225,7 +306,7 @@ func (s *baseCallService) createRoom(c *data.Call) error {
 		return err
 	}
 
-	_, err = s.all.Livekit.CreateRoom(c.RoomName)
+	_, err = s.rooms.CreateRoom(c.RoomName)
 	if err != nil {
 		c.Status = data.CallStatusLost
 		s.dao.Calls.Save(c)
@@ -282,10 +363,25 @@ func (s *baseCallService) setEndCallInfo(call *data.Call, msg *data.Message) {
 	msg.Type = data.CallStartMessage
 }
 
+func (s *baseCallService) auditCallEnd(c *data.Call, status int) {
+	payload := map[string]interface{}{"status": status}
+	if c.Start != nil {
+		payload["duration"] = int(time.Since(*c.Start).Seconds())
+	}
+	s.dao.Audit.Add(data.AuditRecord{Action: data.AuditCallEnd, ChatID: c.ChatID, TargetID: c.ID}, payload)
+}
+
 func (s *baseCallService) end(c *data.Call) error {
-	if s.LivekitEnabled {
+	s.auditCallEnd(c, c.Status)
+	// egress should be stopped before the room is deleted
+	s.all.Recordings.Finish(c)
+	if s.rooms != nil && c.RoomName != "" {
 		// should delete the room as the call has been ended
-		go s.all.Livekit.DeleteRoom(c.RoomName)
+		go s.rooms.DeleteRoom(c.RoomName)
+	}
+	err := s.dao.CallUsers.EndCall(c.ID)
+	if err != nil {
+		return err
 	}
-	return s.dao.CallUsers.EndCall(c.ID)
+	return s.dao.Users.RemoveGuests(c.ID)
 }
@@ -7,15 +7,20 @@ import (
 )
 
 type Call struct {
-	ID          int        `json:"id"`
-	Status      int        `json:"status"`
-	InitiatorID int        `json:"initiator"`
-	ChatID      int        `json:"chat"`
-	Start       *time.Time `json:"start"`
-	IsGroupCall bool       `json:"group"`
-	Name        string     `json:"name"`
-	Avatar      string     `json:"avatar"`
-	Users       []int      `json:"users"`
+	ID          int         `json:"id"`
+	Status      int         `json:"status"`
+	InitiatorID int         `json:"initiator"`
+	ChatID      int         `json:"chat"`
+	Start       *time.Time  `json:"start"`
+	IsGroupCall bool        `json:"group"`
+	Name        string      `json:"name"`
+	Avatar      string      `json:"avatar"`
+	Users       []int       `json:"users"`
+	Locked      bool        `json:"locked"`
+	Action      *CallAction `json:"action,omitempty"`
+	// the state of connected participants, so late joiners see it without asking the media server
+	Media  []ParticipantMedia `json:"media"`
+	Guests []CallGuest        `json:"guests"`
 }
 
 type groupCallService struct {
@@ -27,7 +32,7 @@ func newGroupCallsService(base baseCallService) *groupCallService {
 }
 
 func (s *groupCallService) Start(ctx *CallContext, targetChatId, targetUserId int) (*data.Call, error) {
-	if !s.LivekitEnabled {
+	if s.rooms == nil {
 		return nil, data.ErrFeatureDisabled
 	}
 
@@ -58,17 +63,116 @@ func (s *groupCallService) Start(ctx *CallContext, targetChatId, targetUserId in
 		return nil, err
 	}
 
-	err = s.createRoom(&call)
+	err = s.ring(&call, ctx.UserID)
 	if err != nil {
 		return nil, err
 	}
-	s.all.Informer.SendSignalToCall(&call, call.Status)
+
+	return &call, nil
+}
+
+// startTransfer starts the group call for participants of the personal call, which is still active
+func (s *groupCallService) startTransfer(ctx *CallContext, chatId int, from *data.Call) (*data.Call, error) {
+	err := s.checkUserAccess(chatId, ctx.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := s.dao.Calls.Start(ctx.UserID, ctx.DeviceID, 0, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	// participants of the personal call are not busy for the new call
+	for i := range call.Users {
+		cu := &call.Users[i]
+		if prev := from.GetByUserID(cu.UserID); prev != nil && prev.Status != data.CallUserStatusDisconnected &&
+			cu.Status == data.CallUserStatusDisconnected {
+			err = s.dao.CallUsers.UpdateUserConnState(call.ID, cu.UserID, data.CallUserStatusInitiated)
+			if err != nil {
+				return nil, err
+			}
+			cu.Status = data.CallUserStatusInitiated
+		}
+	}
+
+	err = s.ring(&call, ctx.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &call, nil
+}
+
+// StartScheduled starts the call of the meeting, all members including the organizer are called,
+// and nobody is connected till they join
+func (s *groupCallService) StartScheduled(chatId, organizerId int) (*data.Call, error) {
+	if s.rooms == nil {
+		return nil, data.ErrFeatureDisabled
+	}
+
+	err := s.checkUserAccess(chatId, organizerId)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := s.dao.Calls.CheckIfChatInCall(chatId)
+	if err != nil {
+		return nil, err
+	}
+	if call.ID != 0 {
+		return &call, nil
+	}
+
+	// without a device the organizer is invited as other members
+	call, err = s.dao.Calls.Start(organizerId, 0, 0, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ring(&call, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &call, nil
+}
+
+// ring creates the room of the new call and calls its members, except the initiator
+func (s *groupCallService) ring(call *data.Call, initiatorId int) error {
+	err := s.createRoom(call)
+	if err != nil {
+		return err
+	}
+
+	// users in do-not-disturb mode are not called, they still can join
+	for i := range call.Users {
+		cu := &call.Users[i]
+		if cu.UserID != initiatorId && cu.Status == data.CallUserStatusInitiated &&
+			s.all.UsersActivity.GetStatus(cu.UserID) == data.StatusDoNotDisturb {
+			err = s.dao.CallUsers.UpdateUserConnState(call.ID, cu.UserID, data.CallUserStatusDisconnected)
+			if err != nil {
+				return err
+			}
+			cu.Status = data.CallUserStatusDisconnected
+		}
+	}
+	s.all.Informer.SendSignalToCall(call, call.Status)
 	s.StartCallTimer(s.notAcceptedTimeout, call.ID, s.dropNotAcceptedHandler)
 
-	return &call, err
+	return nil
 }
 
 func (s *groupCallService) Join(ctx *CallContext, call *data.Call) error {
+	cu := call.GetByUserID(ctx.UserID)
+	if call.Locked && (cu == nil || cu.Status != data.CallUserStatusActive && cu.Status != data.CallUserStatusConnecting) {
+		// only participants of the locked call can reconnect
+		return errCallLocked
+	}
+	if cu != nil && cu.Removed {
+		return errRemovedFromCall
+	}
+
 	notify, err := s.updateAcceptedCall(ctx, call)
 	if err != nil {
 		return err
@@ -109,7 +213,7 @@ func (s *groupCallService) Join(ctx *CallContext, call *data.Call) error {
 }
 
 func (s *groupCallService) Disconnect(ctx *CallContext, call *data.Call, status int) error {
-	if !s.LivekitEnabled {
+	if s.rooms == nil {
 		return data.ErrFeatureDisabled
 	}
 	if call.Status > 900 {
@@ -117,7 +221,9 @@ func (s *groupCallService) Disconnect(ctx *CallContext, call *data.Call, status
 		return fmt.Errorf("call already ended")
 	}
 
-	if call.Status == data.CallStatusInitiated && call.InitiatorID == ctx.UserID {
+	// the organizer of the scheduled call is invited as other members, so they only decline it
+	if cu := call.GetByUserID(ctx.UserID); call.Status == data.CallStatusInitiated && call.InitiatorID == ctx.UserID &&
+		(cu == nil || cu.Status != data.CallUserStatusInitiated) {
 		// reject call by the initiator
 		err := s.updateStatusAndSendMessage(call, data.CallStatusRejected)
 		s.all.Informer.SendSignalToCall(call, data.CallStatusRejected)
@@ -136,6 +242,10 @@ func (s *groupCallService) Disconnect(ctx *CallContext, call *data.Call, status
 			currentStatus = cu.Status
 			cu.Status = data.CallUserStatusDisconnected
 		}
+		if cu.Guest {
+			// guests don't keep the call
+			continue
+		}
 
 		if cu.Status == data.CallUserStatusActive {
 			activeCount++
@@ -179,13 +289,13 @@ func (s *groupCallService) Disconnect(ctx *CallContext, call *data.Call, status
 	s.all.Informer.SendSignalToCall(call, data.CallStatusDisconnected, toUsers...)
 
 	// remove participant from the room
-	go s.all.Livekit.DisconnectParticipant(call.RoomName, fmt.Sprint(ctx.UserID))
+	go s.rooms.DisconnectParticipant(call.RoomName, fmt.Sprint(ctx.UserID))
 
 	return err
 }
 
 func (s *groupCallService) RefreshCallUsers(chatId int, users []int) error {
-	if !s.LivekitEnabled {
+	if s.rooms == nil {
 		return nil
 	}
 
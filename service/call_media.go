@@ -0,0 +1,106 @@
+package service
+
+import (
+	"mkozhukh/chat/data"
+)
+
+// kinds of media, the hand is raised by participants to ask for the word
+const (
+	MediaAudio  = "audio"
+	MediaVideo  = "video"
+	MediaScreen = "screen"
+	MediaHand   = "hand"
+)
+
+// CallGuest is the participant, who has joined by the guest link
+type CallGuest struct {
+	UserID int    `json:"user"`
+	Name   string `json:"name"`
+}
+
+// ParticipantMedia is the media state of the participant in call signals
+type ParticipantMedia struct {
+	UserID int `json:"user"`
+	data.MediaState
+}
+
+// CallPayload returns the call as it is sent to clients
+func CallPayload(c *data.Call, status int) Call {
+	return Call{
+		ID:          c.ID,
+		Status:      status,
+		Start:       c.Start,
+		InitiatorID: c.InitiatorID,
+		IsGroupCall: c.IsGroupCall,
+		ChatID:      c.ChatID,
+		Users:       c.GetUsersIDs(false),
+		Locked:      c.Locked,
+		Media:       CallMedia(c),
+		Guests:      CallGuests(c),
+	}
+}
+
+// CallGuests returns names of guests, they are hidden from the users list
+func CallGuests(c *data.Call) []CallGuest {
+	out := make([]CallGuest, 0)
+	for _, cu := range c.Users {
+		if cu.Guest {
+			out = append(out, CallGuest{UserID: cu.UserID, Name: cu.Name})
+		}
+	}
+	return out
+}
+
+// CallMedia returns the media state of connected participants
+func CallMedia(c *data.Call) []ParticipantMedia {
+	out := make([]ParticipantMedia, 0, len(c.Users))
+	for _, cu := range c.Users {
+		if cu.Status == data.CallUserStatusDisconnected {
+			continue
+		}
+		out = append(out, ParticipantMedia{UserID: cu.UserID, MediaState: cu.MediaState})
+	}
+	return out
+}
+
+// SetMedia updates the media state of the participant and sends the call to all participants
+func (s *baseCallService) SetMedia(ctx *CallContext, call *data.Call, kind string, value bool) error {
+	cu := call.GetByUserID(ctx.UserID)
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected || call.Status > 900 {
+		return data.ErrAccessDenied
+	}
+
+	changed, err := s.setMedia(call, cu, kind, value)
+	if err == nil && changed {
+		s.all.Informer.SendSignalToCall(call, call.Status)
+	}
+	return err
+}
+
+func (s *baseCallService) setMedia(call *data.Call, cu *data.CallUser, kind string, value bool) (bool, error) {
+	state := cu.MediaState
+	switch kind {
+	case MediaAudio:
+		state.Audio = value
+	case MediaVideo:
+		state.Video = value
+	case MediaScreen:
+		state.Screen = value
+	case MediaHand:
+		state.Hand = value
+	default:
+		return false, data.ErrWrongValue
+	}
+
+	if state == cu.MediaState {
+		return false, nil
+	}
+
+	err := s.dao.CallUsers.SetMedia(call.ID, cu.UserID, state)
+	if err != nil {
+		return false, err
+	}
+	cu.MediaState = state
+
+	return true, nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"mkozhukh/chat/data"
+)
+
+// actions of call moderators
+const (
+	CallActionMute   = "mute"
+	CallActionRemove = "remove"
+	CallActionLock   = "lock"
+	CallActionEnd    = "end"
+)
+
+// CallAction is sent to participants along with the call
+type CallAction struct {
+	Name     string `json:"name"`
+	UserID   int    `json:"user"`
+	TargetID int    `json:"target,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	// muted for the mute action, locked for the lock one
+	Value bool `json:"value"`
+}
+
+// Mute mutes or unmutes audio or video of the participant
+func (s *groupCallService) Mute(ctx *CallContext, call *data.Call, userId int, kind string, muted bool) error {
+	err := s.checkModerator(ctx, call)
+	if err != nil {
+		return err
+	}
+	if kind != MediaAudio && kind != MediaVideo {
+		return data.ErrWrongValue
+	}
+
+	cu := call.GetByUserID(userId)
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected {
+		return data.ErrWrongValue
+	}
+
+	err = s.rooms.MuteParticipant(call.RoomName, fmt.Sprint(userId), kind, muted)
+	if err != nil {
+		return err
+	}
+	if muted {
+		// the state is sent along with the action
+		_, err = s.setMedia(call, cu, kind, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.all.Informer.SendActionToCall(call, &CallAction{Name: CallActionMute, UserID: ctx.UserID, TargetID: userId, Kind: kind, Value: muted})
+	return nil
+}
+
+// Remove disconnects the participant from the call, the participant can't join it again
+func (s *groupCallService) Remove(ctx *CallContext, call *data.Call, userId int) error {
+	err := s.checkModerator(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	cu := call.GetByUserID(userId)
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected || userId == ctx.UserID {
+		return data.ErrWrongValue
+	}
+
+	err = s.dao.CallUsers.SetRemoved(call.ID, userId)
+	if err != nil {
+		return err
+	}
+	cu.Removed = true
+
+	if s.all.Livekit != nil && call.RoomName != "" {
+		// the removal of the participant from the room is not immediate
+		s.all.Livekit.RevokeParticipant(call.RoomName, fmt.Sprint(userId))
+	}
+
+	err = s.Disconnect(&CallContext{UserID: userId, DeviceID: cu.DeviceID}, call, data.CallStatusDisconnected)
+	if err != nil {
+		return err
+	}
+	if cu.Guest {
+		// the identity of the guest is not used again
+		_, err = s.dao.Users.ChangeOnlineStatus(userId, data.StatusOffline)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.all.Informer.SendActionToCall(call, &CallAction{Name: CallActionRemove, UserID: ctx.UserID, TargetID: userId})
+	return nil
+}
+
+// Lock forbids new participants to join the call
+func (s *groupCallService) Lock(ctx *CallContext, call *data.Call, locked bool) error {
+	err := s.checkModerator(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	call.Locked = locked
+	err = s.dao.Calls.Save(call)
+	if err != nil {
+		return err
+	}
+
+	s.all.Informer.SendActionToCall(call, &CallAction{Name: CallActionLock, UserID: ctx.UserID, Value: locked})
+	return nil
+}
+
+// End ends the call for all participants
+func (s *groupCallService) End(ctx *CallContext, call *data.Call) error {
+	err := s.checkModerator(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	err = s.updateStatusAndSendMessage(call, data.CallStatusEnded)
+	if err != nil {
+		return err
+	}
+
+	s.all.Informer.SendActionToCall(call, &CallAction{Name: CallActionEnd, UserID: ctx.UserID})
+	return nil
+}
+
+// checkModerator allows actions to the initiator of the call and admins of the chat
+func (s *groupCallService) checkModerator(ctx *CallContext, call *data.Call) error {
+	if s.rooms == nil {
+		return data.ErrFeatureDisabled
+	}
+	if !call.IsGroupCall || call.Status > 900 {
+		return data.ErrWrongValue
+	}
+	if call.InitiatorID != ctx.UserID && !s.dao.Chats.IsAdmin(call.ChatID, ctx.UserID) {
+		return data.ErrAccessDenied
+	}
+
+	return nil
+}
@@ -34,7 +34,13 @@ func (s *personalCallService) Start(ctx *CallContext, targetChatId, targetUserId
 		return &call, err
 	}
 
-	c, err := s.checkUserBusy(ctx, targetChatId, targetUserId)
+	// do not reveal the busy state to blocked users
+	err = s.dao.Chats.CheckDirectAccess(targetChatId, ctx.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, waiting, err := s.checkUserBusy(ctx, targetChatId, targetUserId)
 	if err != nil {
 		return c, err
 	}
@@ -44,6 +50,19 @@ func (s *personalCallService) Start(ctx *CallContext, targetChatId, targetUserId
 		return nil, err
 	}
 
+	if waiting {
+		// the callee is in another call, it is told about the incoming one
+		// and can put the current call on hold or decline the new one
+		err = s.dao.CallUsers.UpdateUserConnState(call.ID, targetUserId, data.CallUserStatusInitiated)
+		if err != nil {
+			return nil, err
+		}
+		if cu := call.GetByUserID(targetUserId); cu != nil {
+			cu.Status = data.CallUserStatusInitiated
+		}
+	}
+
+	// personal calls use the media server of LiveKit only, the built-in one is for group calls
 	if s.LivekitEnabled {
 		err = s.createRoom(&call)
 		if err != nil {
@@ -57,6 +76,12 @@ func (s *personalCallService) Start(ctx *CallContext, targetChatId, targetUserId
 }
 
 func (s *personalCallService) Join(ctx *CallContext, call *data.Call) error {
+	// accepting of the waiting call holds the current one
+	err := s.holdOtherCalls(ctx, call.ID)
+	if err != nil {
+		return err
+	}
+
 	notify, err := s.updateAcceptedCall(ctx, call)
 	if err != nil {
 		return err
@@ -106,20 +131,115 @@ func (s *personalCallService) dropNotAcceptedHandler(id int) {
 	}
 }
 
-func (s *personalCallService) checkUserBusy(ctx *CallContext, toChatId, toUserId int) (*data.Call, error) {
-	call, err := s.dao.Calls.GetByUser(toUserId)
+// Transfer moves the direct call to the group call of the new chat with the added users,
+// the chat is removed if the call can't be moved, ready is called before clients switch to the new call
+func (s *personalCallService) Transfer(ctx *CallContext, call *data.Call, users []int, ready func(chatId int)) (*data.Call, error) {
+	err := s.CanTransfer(ctx, call)
 	if err != nil {
 		return nil, err
 	}
-	if call.ID != 0 {
+
+	users = append(users, ctx.UserID)
+	chatId, err := s.dao.Chats.AddGroup(s.dao.Users.GetGroupName(users), "", users, ctx.UserID)
+	if err != nil {
+		if chatId != 0 {
+			s.dao.Chats.Delete(chatId)
+		}
+		return nil, err
+	}
+
+	// the group call is started first, so the personal call is kept if it fails
+	next, err := s.all.GroupCalls.startTransfer(ctx, chatId, call)
+	if err != nil {
+		s.dao.Chats.Delete(chatId)
+		return nil, err
+	}
+
+	// the other side is already in the call, so it joins without ringing
+	for _, u := range call.Users {
+		if u.UserID != ctx.UserID && u.Status != data.CallUserStatusDisconnected && u.DeviceID != 0 {
+			err = s.all.GroupCalls.Join(&CallContext{UserID: u.UserID, DeviceID: u.DeviceID}, next)
+			if err != nil {
+				s.updateStatusAndSendMessage(next, data.CallStatusRejected)
+				s.all.Informer.SendSignalToCall(next, data.CallStatusRejected)
+				s.dao.Chats.Delete(chatId)
+				return nil, err
+			}
+		}
+	}
+	ready(chatId)
+
+	old := *call
+	old.Users = append([]data.CallUser{}, call.Users...)
+	err = s.updateStatusAndSendMessage(call, data.CallStatusEnded)
+	if err != nil {
+		return nil, err
+	}
+
+	// clients switch to the new call before they receive the end of the old one
+	s.all.Informer.SendSignalToParticipants(&old, TransferSignal, TransferEvent{
+		From: old.ID,
+		Call: CallPayload(next, next.Status),
+	})
+	s.all.Informer.SendSignalToCall(&old, data.CallStatusEnded)
+
+	return next, nil
+}
+
+// CanTransfer checks that the active personal call of the user can be moved to a group call
+func (s *personalCallService) CanTransfer(ctx *CallContext, call *data.Call) error {
+	if !data.Features.WithGroupCalls || s.all.GroupCalls.rooms == nil {
+		return data.ErrFeatureDisabled
+	}
+
+	cu := call.GetByUserID(ctx.UserID)
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected {
+		return data.ErrAccessDenied
+	}
+	if call.IsGroupCall || call.Status != data.CallStatusActive {
+		return data.ErrWrongValue
+	}
+
+	// the group call can't be started while other calls are on hold
+	calls, err := s.dao.Calls.GetAllByUser(ctx.UserID)
+	if err != nil {
+		return err
+	}
+	if len(calls) > 1 {
+		return errActiveInOtherChat
+	}
+
+	return nil
+}
+
+// checkUserBusy allows one waiting call for the user in an active call,
+// the line is busy while the user has an incoming or outgoing call, has a call on hold or is in do-not-disturb mode
+func (s *personalCallService) checkUserBusy(ctx *CallContext, toChatId, toUserId int) (*data.Call, bool, error) {
+	calls, err := s.dao.Calls.GetAllByUser(toUserId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	busy := len(calls) > 1
+	for _, c := range calls {
+		if c.Status == data.CallStatusInitiated {
+			busy = true
+		}
+	}
+	// the caller gets the busy status instead of ringing till the timeout
+	if s.all.UsersActivity.GetStatus(toUserId) == data.StatusDoNotDisturb {
+		busy = true
+	}
+
+	if busy {
 		call := data.Call{
 			InitiatorID: ctx.UserID,
 			Status:      data.CallStatusBusy,
 			ChatID:      toChatId,
 		}
 		s.SendCallMessage(&call, data.CallBusyMessage)
-		return &call, errLineIsBusy
+		return &call, false, errLineIsBusy
 	}
 
-	return nil, nil
+	return nil, len(calls) > 0, nil
 }
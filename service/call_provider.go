@@ -9,6 +9,8 @@ var (
 	errActiveInOtherChat = errors.New("#ERR_01")
 	errAlreadyInCall     = errors.New("#ERR_02")
 	errLineIsBusy        = errors.New("#ERR_03")
+	errCallLocked        = errors.New("#ERR_07")
+	errRemovedFromCall   = errors.New("#ERR_08")
 )
 
 type ICallService interface {
@@ -17,6 +19,25 @@ type ICallService interface {
 	Disconnect(ctx *CallContext, c *data.Call, status int) error
 }
 
+// signals of personal calls, sent in addition to the "connect" one
+const (
+	HoldSignal     = "hold"
+	TransferSignal = "transfer"
+)
+
+// HoldEvent informs participants that the user has put the call on hold or resumed it
+type HoldEvent struct {
+	CallID int  `json:"call"`
+	UserID int  `json:"user"`
+	Hold   bool `json:"hold"`
+}
+
+// TransferEvent informs participants that the call continues as the group one
+type TransferEvent struct {
+	From int  `json:"from"`
+	Call Call `json:"call"`
+}
+
 type CallContext struct {
 	UserID   int
 	DeviceID int
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"mkozhukh/chat/data"
+	"time"
+
+	livekit "github.com/livekit/protocol/livekit"
+)
+
+// CallStatsReport contains WebRTC stats of the client, LiveKit clients add their connection quality
+type CallStatsReport struct {
+	RTT     float64 `json:"rtt"`
+	Jitter  float64 `json:"jitter"`
+	Loss    float64 `json:"loss"`
+	Bitrate float64 `json:"bitrate"`
+	// poor, good or excellent
+	Quality string `json:"quality"`
+}
+
+var callQualities = map[string]int{
+	"poor":      data.CallQualityPoor,
+	"good":      data.CallQualityGood,
+	"excellent": data.CallQualityExcellent,
+}
+
+type callStatsService struct {
+	dao *data.DAO
+	all *ServiceAll
+
+	// samples are kept for the time
+	Retention time.Duration
+	// rooms of LiveKit are checked with the interval
+	PollInterval time.Duration
+
+	// time of joining of participants in the previous check, by room and identity
+	joined map[string]int64
+}
+
+func newCallStatsService(dao *data.DAO, all *ServiceAll) *callStatsService {
+	return &callStatsService{
+		dao:          dao,
+		all:          all,
+		Retention:    30 * 24 * time.Hour,
+		PollInterval: 30 * time.Second,
+		joined:       make(map[string]int64),
+	}
+}
+
+// Report stores the sample of the participant of the active call
+func (s *callStatsService) Report(ctx *CallContext, callId int, r CallStatsReport) error {
+	call, err := s.dao.Calls.Get(callId)
+	if err != nil {
+		return err
+	}
+
+	cu := call.GetByUserID(ctx.UserID)
+	// ringing users have no media yet
+	if cu == nil || cu.Status == data.CallUserStatusDisconnected || cu.Status == data.CallUserStatusInitiated || call.Status > 900 {
+		return data.ErrAccessDenied
+	}
+
+	return s.dao.CallStats.Add(&data.CallStat{
+		CallID:  call.ID,
+		UserID:  ctx.UserID,
+		Date:    time.Now(),
+		RTT:     statValue(r.RTT, math.MaxFloat32),
+		Jitter:  statValue(r.Jitter, math.MaxFloat32),
+		Loss:    statValue(r.Loss, 100),
+		Bitrate: statValue(r.Bitrate, math.MaxFloat32),
+		Quality: callQualities[r.Quality],
+	})
+}
+
+// Dropped marks the participant, which has not reconnected in time
+func (s *callStatsService) Dropped(callId, userId int) error {
+	return s.dao.CallStats.Add(&data.CallStat{
+		CallID:  callId,
+		UserID:  userId,
+		Date:    time.Now(),
+		Dropped: true,
+	})
+}
+
+// Start takes samples of participants from LiveKit rooms, the room service doesn't expose the connection quality,
+// so only the state of participants is stored
+func (s *callStatsService) Start() {
+	if s.all.Livekit == nil {
+		return
+	}
+	go s.run()
+}
+
+func (s *callStatsService) run() {
+	for range time.Tick(s.PollInterval) {
+		s.poll(time.Now())
+	}
+}
+
+func (s *callStatsService) poll(now time.Time) {
+	calls, err := s.dao.Calls.GetActiveInRooms()
+	if err != nil {
+		return
+	}
+
+	joined := make(map[string]int64)
+	for i := range calls {
+		call := &calls[i]
+		list, err := s.all.Livekit.ListParticipants(call.RoomName)
+		if err != nil {
+			log.Println("[stats]", err.Error())
+			continue
+		}
+
+		inRoom := make(map[string]*livekit.ParticipantInfo)
+		for _, p := range list {
+			if p.State != livekit.ParticipantInfo_DISCONNECTED {
+				inRoom[p.Identity] = p
+			}
+		}
+
+		for _, cu := range call.Users {
+			// reconnecting participants are marked as dropped if they don't return in time
+			if cu.Status != data.CallUserStatusActive {
+				continue
+			}
+
+			sample := data.CallStat{
+				CallID: call.ID,
+				UserID: cu.UserID,
+				Date:   now,
+				Server: true,
+			}
+
+			p, ok := inRoom[fmt.Sprint(cu.UserID)]
+			if ok {
+				key := call.RoomName + "/" + p.Identity
+				joined[key] = p.JoinedAt
+				prev, seen := s.joined[key]
+				sample.Rejoined = seen && prev != p.JoinedAt
+				sample.Tracks = publishedTracks(p)
+			} else {
+				sample.Absent = true
+			}
+
+			s.dao.CallStats.Add(&sample)
+		}
+	}
+
+	// participants of ended calls are not kept
+	s.joined = joined
+}
+
+func publishedTracks(p *livekit.ParticipantInfo) int {
+	count := 0
+	for _, t := range p.Tracks {
+		if !t.Muted {
+			count++
+		}
+	}
+	return count
+}
+
+func statValue(v, max float64) float64 {
+	if math.IsNaN(v) || v < 0 {
+		return 0
+	}
+	return math.Min(v, max)
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"log"
+	"mkozhukh/chat/data"
+	"time"
+)
+
+type draftsService struct {
+	dao *data.DAO
+	TTL time.Duration
+}
+
+func newDraftsService(dao *data.DAO) *draftsService {
+	s := &draftsService{
+		dao: dao,
+		TTL: 24 * time.Hour,
+	}
+	go s.runCleanup()
+
+	return s
+}
+
+// runCleanup removes files which were uploaded but never sent, and abandoned uploads
+func (s *draftsService) runCleanup() {
+	for range time.Tick(10 * time.Minute) {
+		before := time.Now().Add(-s.TTL)
+
+		count, err := s.dao.Attachments.RemoveExpiredDrafts(before)
+		if err != nil {
+			log.Println("[drafts]", err.Error())
+		} else if count > 0 {
+			log.Printf("[drafts] %d expired drafts removed", count)
+		}
+
+		count, err = s.dao.Uploads.RemoveExpired(before)
+		if err != nil {
+			log.Println("[uploads]", err.Error())
+		} else if count > 0 {
+			log.Printf("[uploads] %d expired uploads removed", count)
+		}
+	}
+}
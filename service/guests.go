@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"mkozhukh/chat/data"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	livekit "github.com/livekit/protocol/livekit"
+)
+
+// GuestJoin is returned to the guest, the token is used to join the LiveKit room
+type GuestJoin struct {
+	UserID int    `json:"user"`
+	Token  string `json:"token"`
+	Call   Call   `json:"call"`
+}
+
+type guestsService struct {
+	dao    *data.DAO
+	all    *ServiceAll
+	server string
+
+	LinkTTL   time.Duration
+	MaxGuests int
+	// the guest is disconnected if it is not in the room after the time
+	ConnectTimeout time.Duration
+
+	mu     sync.Mutex
+	joined map[int]time.Time
+}
+
+func newGuestsService(dao *data.DAO, all *ServiceAll) *guestsService {
+	return &guestsService{
+		dao:            dao,
+		all:            all,
+		LinkTTL:        24 * time.Hour,
+		MaxGuests:      20,
+		ConnectTimeout: time.Minute,
+		joined:         make(map[int]time.Time),
+	}
+}
+
+// SetServer sets the public url, it is used in guest links
+func (s *guestsService) SetServer(public string) {
+	s.server = strings.TrimRight(public, "/")
+}
+
+// Link returns the signed url to join the group call, it is allowed for moderators of the call
+func (s *guestsService) Link(ctx *CallContext, callId int) (string, error) {
+	if s.all.Livekit == nil {
+		// guests connect to LiveKit rooms directly
+		return "", data.ErrFeatureDisabled
+	}
+
+	call, err := s.dao.Calls.Get(callId)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.all.GroupCalls.checkModerator(ctx, &call)
+	if err != nil {
+		return "", err
+	}
+
+	return s.all.FileLinks.SignFor(s.server+GuestPath(call.ID), s.LinkTTL)
+}
+
+// Join creates the temporary user for the guest and adds it to the call,
+// guests are not members of the chat and are removed with the room when the call ends
+func (s *guestsService) Join(callId int, name string) (*GuestJoin, error) {
+	if s.all.Livekit == nil {
+		return nil, data.ErrFeatureDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		return nil, data.ErrWrongValue
+	}
+
+	call, err := s.dao.Calls.Get(callId)
+	if err != nil {
+		return nil, err
+	}
+	if !call.IsGroupCall || call.Status > 900 || call.RoomName == "" {
+		return nil, data.ErrAccessDenied
+	}
+	if call.Locked {
+		return nil, errCallLocked
+	}
+
+	guests := 0
+	for _, u := range call.Users {
+		if u.Guest {
+			guests++
+		}
+	}
+	if guests >= s.MaxGuests {
+		return nil, data.ErrAccessDenied
+	}
+
+	u, err := s.dao.Users.AddGuest(data.SafeHTML(name))
+	if err != nil {
+		return nil, err
+	}
+
+	cu, err := s.dao.CallUsers.AddGuest(call.ID, int(u.ID))
+	if err != nil {
+		return nil, err
+	}
+	cu.Name = u.Name
+	call.Users = append(call.Users, cu)
+
+	s.mu.Lock()
+	s.joined[cu.UserID] = time.Now()
+	s.mu.Unlock()
+
+	token, err := s.all.Livekit.CreateJoinToken(call.RoomName, fmt.Sprint(u.ID), u.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.all.Informer.SendSignalToCall(&call, call.Status)
+
+	return &GuestJoin{
+		UserID: int(u.ID),
+		Token:  token,
+		Call:   CallPayload(&call, call.Status),
+	}, nil
+}
+
+// Start checks rooms of calls with guests, guests don't use the API,
+// so they are disconnected from the call when they leave its room
+func (s *guestsService) Start() {
+	if s.all.Livekit == nil {
+		return
+	}
+	go s.run()
+}
+
+func (s *guestsService) run() {
+	for range time.Tick(30 * time.Second) {
+		s.check(time.Now())
+	}
+}
+
+func (s *guestsService) check(now time.Time) {
+	s.mu.Lock()
+	for id, t := range s.joined {
+		// after the timeout the guest is checked as others
+		if now.Sub(t) >= s.ConnectTimeout {
+			delete(s.joined, id)
+		}
+	}
+	s.mu.Unlock()
+
+	guests, err := s.dao.CallUsers.GetConnectedGuests()
+	if err != nil {
+		return
+	}
+
+	calls := make(map[int][]int)
+	for _, g := range guests {
+		calls[g.CallID] = append(calls[g.CallID], g.UserID)
+	}
+
+	for id, users := range calls {
+		call, err := s.dao.Calls.Get(id)
+		if err != nil {
+			continue
+		}
+
+		list, err := s.all.Livekit.ListParticipants(call.RoomName)
+		if err != nil {
+			log.Println("[guests]", err.Error())
+			continue
+		}
+		inRoom := make(map[string]bool)
+		for _, p := range list {
+			if p.State != livekit.ParticipantInfo_DISCONNECTED {
+				inRoom[p.Identity] = true
+			}
+		}
+
+		changed := false
+		for _, uid := range users {
+			if inRoom[fmt.Sprint(uid)] || now.Sub(s.joinedAt(uid)) < s.ConnectTimeout {
+				continue
+			}
+
+			err = s.leave(&call, uid)
+			if err != nil {
+				log.Println("[guests]", err.Error())
+				continue
+			}
+			changed = true
+		}
+
+		if changed {
+			s.all.Informer.SendSignalToCall(&call, call.Status)
+		}
+	}
+}
+
+// joinedAt returns the time of joining, guests of the previous run of the server have already connected
+func (s *guestsService) joinedAt(userId int) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.joined[userId]
+}
+
+// leave disconnects the guest, which has left the room, it doesn't count toward the limit of guests anymore
+func (s *guestsService) leave(call *data.Call, userId int) error {
+	err := s.dao.CallUsers.UpdateUserConnState(call.ID, userId, data.CallUserStatusDisconnected)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.dao.Users.ChangeOnlineStatus(userId, data.StatusOffline)
+	if err != nil {
+		return err
+	}
+
+	if cu := call.GetByUserID(userId); cu != nil {
+		cu.Status = data.CallUserStatusDisconnected
+		cu.MediaState = data.MediaState{}
+	}
+	return nil
+}
+
+// GuestPath is the path of the guest link, the guest sends its name there
+func GuestPath(callId int) string {
+	return fmt.Sprintf("/api/v1/calls/%d/guest", callId)
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TurnConfig struct {
+	// e.g. turn:turn.example.com:3478?transport=udp
+	URLs []string
+	// shared secret of the TURN REST API ( static-auth-secret of coturn )
+	Secret string
+	// lifetime of credentials, in seconds
+	TTL int `default:"86400"`
+}
+
+// ICEServer is the RTCIceServer of the browser API
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type iceService struct {
+	stun []string
+	turn TurnConfig
+}
+
+func newICEService() *iceService {
+	return &iceService{}
+}
+
+// SetConfig sets comma separated STUN urls and TURN servers
+func (s *iceService) SetConfig(stun string, turn TurnConfig) {
+	s.stun = nil
+	for _, u := range strings.Split(stun, ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			s.stun = append(s.stun, u)
+		}
+	}
+
+	if turn.TTL <= 0 {
+		turn.TTL = 86400
+	}
+	s.turn = turn
+}
+
+// Servers returns STUN servers and TURN servers with credentials of the user
+func (s *iceService) Servers(userId int) []ICEServer {
+	out := make([]ICEServer, 0, 2)
+	if len(s.stun) > 0 {
+		out = append(out, ICEServer{URLs: s.stun})
+	}
+	if len(s.turn.URLs) > 0 && s.turn.Secret != "" {
+		username, credential := s.credentials(userId, time.Now())
+		out = append(out, ICEServer{URLs: s.turn.URLs, Username: username, Credential: credential})
+	}
+
+	return out
+}
+
+// STUN returns urls of STUN servers
+func (s *iceService) STUN() []string {
+	return s.stun
+}
+
+// credentials are created by the TURN REST API scheme, the username contains the expiration time
+// and the password is HMAC-SHA1 of the username, so the TURN server checks them without the database
+func (s *iceService) credentials(userId int, now time.Time) (string, string) {
+	exp := now.Add(time.Duration(s.turn.TTL) * time.Second).Unix()
+	username := strconv.FormatInt(exp, 10) + ":" + strconv.Itoa(userId)
+
+	mac := hmac.New(sha1.New, []byte(s.turn.Secret))
+	mac.Write([]byte(username))
+
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestICECredentials(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		secret     string
+		ttl        int
+		user       int
+		username   string
+		credential string
+	}{
+		{"default ttl", "secret", 0, 1, "1704153600:1", "wJ83Xqzw8FiJEdaoIqXn5id3l8E="},
+		{"negative ttl", "secret", -1, 1, "1704153600:1", "wJ83Xqzw8FiJEdaoIqXn5id3l8E="},
+		{"custom ttl", "secret", 3600, 42, "1704070800:42", "ZdVZaJ9uVX9MH/qdtkaMhMZCFqE="},
+		{"other secret", "other", 86400, 1, "1704153600:1", "mxPVd4LZwYsGRI0N/ZARvt4PzpY="},
+		{"no user", "secret", 86400, 0, "1704153600:0", "51CEWb2wHIxRb86psixES83R468="},
+	}
+
+	for _, c := range cases {
+		s := newICEService()
+		s.SetConfig("", TurnConfig{URLs: []string{"turn:localhost:3478"}, Secret: c.secret, TTL: c.ttl})
+
+		username, credential := s.credentials(c.user, now)
+		if username != c.username || credential != c.credential {
+			t.Errorf("%s: %s / %s, want %s / %s", c.name, username, credential, c.username, c.credential)
+		}
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	cases := []struct {
+		name    string
+		stun    string
+		turn    TurnConfig
+		count   int
+		hasTurn bool
+	}{
+		{"nothing", "", TurnConfig{}, 0, false},
+		{"stun", " stun:a:3478, ,stun:b:3478", TurnConfig{}, 1, false},
+		{"turn without secret", "", TurnConfig{URLs: []string{"turn:a:3478"}}, 0, false},
+		{"stun and turn", "stun:a:3478", TurnConfig{URLs: []string{"turn:a:3478"}, Secret: "secret"}, 2, true},
+	}
+
+	for _, c := range cases {
+		s := newICEService()
+		s.SetConfig(c.stun, c.turn)
+
+		out := s.Servers(1)
+		if len(out) != c.count {
+			t.Errorf("%s: %d servers, want %d", c.name, len(out), c.count)
+			continue
+		}
+		if c.hasTurn && (out[len(out)-1].Username == "" || out[len(out)-1].Credential == "") {
+			t.Errorf("%s: turn server without credentials", c.name)
+		}
+	}
+
+	s := newICEService()
+	s.SetConfig(" stun:a:3478, ,stun:b:3478", TurnConfig{})
+	if stun := s.STUN(); len(stun) != 2 || stun[0] != "stun:a:3478" || stun[1] != "stun:b:3478" {
+		t.Errorf("stun urls are %v", stun)
+	}
+}
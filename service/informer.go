@@ -19,14 +19,23 @@ type Signal struct {
 	Devices []int  `json:"-"`
 }
 
+type UserEvent struct {
+	Op      string      `json:"op"`
+	UserID  int         `json:"user_id"`
+	Data    interface{} `json:"data"`
+	Privacy int         `json:"-"`
+}
+
 type informerService struct {
 	dao *data.DAO
+	all *ServiceAll
 	hub *remote.Hub
 }
 
-func newInformerService(dao *data.DAO, hub *remote.Hub) *informerService {
+func newInformerService(dao *data.DAO, all *ServiceAll, hub *remote.Hub) *informerService {
 	return &informerService{
 		dao: dao,
+		all: all,
 		hub: hub,
 	}
 }
@@ -49,19 +58,21 @@ func (s *informerService) SendSignal(kind string, payload interface{}, users, de
 }
 
 func (s *informerService) SendSignalToCall(c *data.Call, status int, to ...data.CallUser) {
+	s.sendCall(c, status, nil, to)
+}
+
+// SendActionToCall sends the call with the moderator's action to all participants
+func (s *informerService) SendActionToCall(c *data.Call, action *CallAction) {
+	s.sendCall(c, 0, action, nil)
+}
+
+func (s *informerService) sendCall(c *data.Call, status int, action *CallAction, to []data.CallUser) {
 	if status == 0 {
 		status = c.Status
 	}
 
-	msgData := Call{
-		ID:          c.ID,
-		Status:      status,
-		Start:       c.Start,
-		InitiatorID: c.InitiatorID,
-		IsGroupCall: c.IsGroupCall,
-		ChatID:      c.ChatID,
-		Users:       c.GetUsersIDs(false),
-	}
+	msgData := CallPayload(c, status)
+	msgData.Action = action
 
 	var devices []int
 	var users []int
@@ -71,13 +82,40 @@ func (s *informerService) SendSignalToCall(c *data.Call, status int, to ...data.
 			users = append(users, cu.UserID)
 		}
 	} else {
-		devices = c.GetDevicesIDs(true)
-		users = c.GetUsersIDs(true)
+		for _, cu := range c.Users {
+			if cu.Status == data.CallUserStatusDisconnected {
+				continue
+			}
+			devices = append(devices, cu.DeviceID)
+			users = append(users, cu.UserID)
+		}
 	}
 
 	s.SendSignal("connect", msgData, users, devices)
 }
 
+// SendSignalToParticipants sends the signal to all connected users of the call
+func (s *informerService) SendSignalToParticipants(c *data.Call, kind string, payload interface{}) {
+	var devices []int
+	var users []int
+	for _, cu := range c.Users {
+		if cu.Status == data.CallUserStatusDisconnected {
+			continue
+		}
+		devices = append(devices, cu.DeviceID)
+		users = append(users, cu.UserID)
+	}
+
+	s.SendSignal(kind, payload, users, devices)
+}
+
+func (s *informerService) SendUserStatus(u *data.User) {
+	s.hub.Publish("users", UserEvent{Op: "online", UserID: int(u.ID), Data: u.Status})
+	if u.Status == data.StatusOffline && u.LastSeen != nil {
+		s.hub.Publish("users", UserEvent{Op: "seen", UserID: int(u.ID), Data: u.LastSeen, Privacy: u.LastSeenPrivacy})
+	}
+}
+
 func (s *informerService) SendSignalToUser(targetUserId int, payload interface{}) {
 	s.SendSignal("connect", payload, []int{targetUserId}, []int{0})
 }
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"log"
+	"mkozhukh/chat/data"
+	"time"
+)
+
+type janitorService struct {
+	dao *data.DAO
+	all *ServiceAll
+}
+
+func newJanitorService(dao *data.DAO, all *ServiceAll) *janitorService {
+	return &janitorService{dao: dao, all: all}
+}
+
+// Start runs the hourly cleanup, it must be called after the hub is set, as removed files are replaced with notices in messages
+func (s *janitorService) Start() {
+	go s.run()
+}
+
+// run removes attachments, which are older than the retention period of their chats, and old quality samples of calls
+func (s *janitorService) run() {
+	for range time.Tick(time.Hour) {
+		count, err := s.dao.Files.ApplyRetention(time.Now())
+		if err != nil {
+			log.Println("[janitor]", err.Error())
+		}
+		if count > 0 {
+			log.Printf("[janitor] %d expired files removed", count)
+		}
+
+		s.dao.CallStats.RemoveOld(time.Now().Add(-s.all.CallStats.Retention))
+	}
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mkozhukh/chat/data"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("#ERR_04")
+	ErrSlowMode    = errors.New("#ERR_05")
+)
+
+// RateLimitRule describes a token bucket, rate is the count of tokens restored per second
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig contains limits for remote methods ( "message.Add" ) and http routes ( "file" )
+type RateLimitConfig struct {
+	Enabled bool
+	User    map[string]RateLimitRule
+	Device  map[string]RateLimitRule
+	IP      map[string]RateLimitRule
+}
+
+// limits of anonymous requests, they are applied even when limits are disabled
+var defaultIPLimits = map[string]RateLimitRule{
+	"token": {Rate: 0.1, Burst: 10},
+	"guest": {Rate: 0.05, Burst: 5},
+}
+
+// LimitStore keeps the state of token buckets
+// Allow takes a token from each of the buckets only when all of them have one
+type LimitStore interface {
+	Allow(buckets map[string]RateLimitRule) bool
+}
+
+type limitsService struct {
+	dao    *data.DAO
+	config RateLimitConfig
+	store  LimitStore
+}
+
+func newLimitsService(dao *data.DAO, config RateLimitConfig) *limitsService {
+	return &limitsService{
+		dao:    dao,
+		config: config,
+		store:  newMemoryLimitStore(),
+	}
+}
+
+func (s *limitsService) SetStore(store LimitStore) {
+	s.store = store
+}
+
+// Take consumes a token of the named action for the user and the device
+func (s *limitsService) Take(name string, userId, deviceId int) error {
+	if !s.config.Enabled {
+		return nil
+	}
+
+	buckets := make(map[string]RateLimitRule, 2)
+	if rule, ok := s.config.User[name]; ok && userId != 0 {
+		buckets[fmt.Sprintf("u:%d:%s", userId, name)] = rule
+	}
+	if rule, ok := s.config.Device[name]; ok && deviceId != 0 {
+		buckets[fmt.Sprintf("d:%d:%s", deviceId, name)] = rule
+	}
+
+	if len(buckets) > 0 && !s.store.Allow(buckets) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// TakeIP consumes a token of the named action for the client address, used for requests without a valid user
+func (s *limitsService) TakeIP(name, ip string) error {
+	rule, ok := s.config.IP[name]
+	if !ok {
+		rule, ok = defaultIPLimits[name]
+	}
+	if !ok {
+		return nil
+	}
+
+	if !s.store.Allow(map[string]RateLimitRule{fmt.Sprintf("ip:%s:%s", ip, name): rule}) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// CheckSlowMode allows one message per N seconds for each chat member, admins are not limited
+func (s *limitsService) CheckSlowMode(chatId, userId int) error {
+	chat, err := s.dao.Chats.GetOne(chatId)
+	if err != nil {
+		return err
+	}
+	if chat.SlowMode <= 0 || s.dao.Chats.IsAdmin(chatId, userId) {
+		return nil
+	}
+
+	rule := RateLimitRule{Rate: 1 / float64(chat.SlowMode), Burst: 1}
+	if !s.store.Allow(map[string]RateLimitRule{fmt.Sprintf("s:%d:%d", chatId, userId): rule}) {
+		return ErrSlowMode
+	}
+
+	return nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rule   RateLimitRule
+}
+
+type memoryLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newMemoryLimitStore() *memoryLimitStore {
+	store := memoryLimitStore{
+		buckets: make(map[string]*tokenBucket),
+	}
+	go store.runCleanup()
+	return &store
+}
+
+func (s *memoryLimitStore) Allow(buckets map[string]RateLimitRule) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	taken := make([]*tokenBucket, 0, len(buckets))
+	for key, rule := range buckets {
+		b := s.refill(key, rule, now)
+		if b.tokens < 1 {
+			return false
+		}
+		taken = append(taken, b)
+	}
+
+	for _, b := range taken {
+		b.tokens--
+	}
+	return true
+}
+
+func (s *memoryLimitStore) refill(key string, rule RateLimitRule, now time.Time) *tokenBucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rule.Burst), last: now, rule: rule}
+		s.buckets[key] = b
+		return b
+	}
+
+	b.rule = rule
+	b.tokens += now.Sub(b.last).Seconds() * rule.Rate
+	if b.tokens > float64(rule.Burst) {
+		b.tokens = float64(rule.Burst)
+	}
+	b.last = now
+	return b
+}
+
+func (s *memoryLimitStore) runCleanup() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			// drop buckets which are full again
+			if b.tokens+now.Sub(b.last).Seconds()*b.rule.Rate >= float64(b.rule.Burst) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
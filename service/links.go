@@ -0,0 +1,94 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fileLinksService struct {
+	key    []byte
+	prefix string
+	TTL    time.Duration
+}
+
+func newFileLinksService() *fileLinksService {
+	// random key works for a single instance only, the shared one must be set through config
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return &fileLinksService{
+		key: key,
+		TTL: 15 * time.Minute,
+	}
+}
+
+func (s *fileLinksService) SetKey(key string) {
+	if key != "" {
+		s.key = []byte(key)
+	}
+}
+
+// SetServer sets the public url of the server, the path of which is not a part of routes
+func (s *fileLinksService) SetServer(public string) {
+	u, err := url.Parse(public)
+	if err == nil {
+		s.prefix = strings.TrimRight(u.Path, "/")
+	}
+}
+
+// RoutePath returns the path of the link as it is seen by the router, without the public prefix
+func (s *fileLinksService) RoutePath(u *url.URL) string {
+	if s.prefix != "" && strings.HasPrefix(u.Path, s.prefix+"/") {
+		return strings.TrimPrefix(u.Path, s.prefix)
+	}
+	return u.Path
+}
+
+// Sign adds expiration time and signature to the url
+func (s *fileLinksService) Sign(link string) (string, error) {
+	return s.SignFor(link, s.TTL)
+}
+
+// SignFor signs the url, which is valid for the given time
+func (s *fileLinksService) SignFor(link string, ttl time.Duration) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := u.Query()
+	q.Set("exp", exp)
+	q.Set("sig", s.signature(s.RoutePath(u), exp))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Verify checks the signature of the route path
+func (s *fileLinksService) Verify(path string, q url.Values) bool {
+	exp := q.Get("exp")
+	sig := q.Get("sig")
+	if exp == "" || sig == "" {
+		return false
+	}
+
+	t, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > t {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(s.signature(path, exp)))
+}
+
+func (s *fileLinksService) signature(path, exp string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(path + "\n" + exp))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
+	"path"
+	"path/filepath"
 	"time"
 
 	"github.com/livekit/protocol/auth"
@@ -14,12 +17,20 @@ type LivekitConfig struct {
 	Host      string
 	ApiKey    string
 	ApiSecret string
+	// folder of recordings in the egress service and the same folder mounted to the chat,
+	// recordings are disabled if not set
+	EgressPath     string
+	RecordingsPath string
 }
 
 type livekitService struct {
-	lksClient  *lksdk.RoomServiceClient
-	APIKey     string
-	APISercret string
+	lksClient    *lksdk.RoomServiceClient
+	egressClient *lksdk.EgressClient
+	APIKey       string
+	APISercret   string
+
+	egressPath     string
+	recordingsPath string
 }
 
 func newLivekitService(cfg LivekitConfig) *livekitService {
@@ -28,10 +39,69 @@ func newLivekitService(cfg LivekitConfig) *livekitService {
 	}
 
 	return &livekitService{
-		lksClient:  lksdk.NewRoomServiceClient(cfg.Host, cfg.ApiKey, cfg.ApiSecret),
-		APIKey:     cfg.ApiKey,
-		APISercret: cfg.ApiSecret,
+		lksClient:      lksdk.NewRoomServiceClient(cfg.Host, cfg.ApiKey, cfg.ApiSecret),
+		egressClient:   lksdk.NewEgressClient(cfg.Host, cfg.ApiKey, cfg.ApiSecret),
+		APIKey:         cfg.ApiKey,
+		APISercret:     cfg.ApiSecret,
+		egressPath:     cfg.EgressPath,
+		recordingsPath: cfg.RecordingsPath,
+	}
+}
+
+// CanRecord checks that the folder of recordings is shared with the egress service
+func (s *livekitService) CanRecord() bool {
+	return s.egressPath != "" && s.recordingsPath != ""
+}
+
+// StartRecording starts the composite recording of the room to mp4 file, returns id of the egress and the local path of the file
+func (s *livekitService) StartRecording(roomName, fileName string) (string, string, error) {
+	info, err := s.egressClient.StartRoomCompositeEgress(context.Background(), &livekit.RoomCompositeEgressRequest{
+		RoomName: roomName,
+		Output: &livekit.RoomCompositeEgressRequest_File{
+			File: &livekit.EncodedFileOutput{
+				FileType: livekit.EncodedFileType_MP4,
+				Filepath: path.Join(s.egressPath, fileName),
+			},
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return info.GetEgressId(), filepath.Join(s.recordingsPath, fileName), nil
+}
+
+func (s *livekitService) StopRecording(egressId string) error {
+	_, err := s.egressClient.StopEgress(context.Background(), &livekit.StopEgressRequest{
+		EgressId: egressId,
+	})
+	return err
+}
+
+// RecordingStatus returns true when the file is completely written, or an error if the egress has failed
+func (s *livekitService) RecordingStatus(roomName, egressId string) (bool, error) {
+	res, err := s.egressClient.ListEgress(context.Background(), &livekit.ListEgressRequest{
+		RoomName: roomName,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range res.GetItems() {
+		if e.GetEgressId() != egressId {
+			continue
+		}
+
+		switch e.GetStatus() {
+		case livekit.EgressStatus_EGRESS_COMPLETE, livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+			return true, nil
+		case livekit.EgressStatus_EGRESS_FAILED, livekit.EgressStatus_EGRESS_ABORTED:
+			return false, errors.New(e.GetError())
+		}
+		return false, nil
 	}
+
+	return false, errors.New("egress not found")
 }
 
 func (s *livekitService) CreateRoom(name string) (string, error) {
@@ -49,6 +119,18 @@ func (s *livekitService) DeleteRoom(name string) error {
 	return err
 }
 
+// ListParticipants returns participants of the room with their state and published tracks
+func (s *livekitService) ListParticipants(roomName string) ([]*livekit.ParticipantInfo, error) {
+	res, err := s.lksClient.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{
+		Room: roomName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.GetParticipants(), nil
+}
+
 func (s *livekitService) DisconnectParticipant(roomName, userId string) error {
 	res, _ := s.lksClient.ListParticipants(context.Background(), &livekit.ListParticipantsRequest{
 		Room: roomName,
@@ -77,7 +159,55 @@ func (s *livekitService) DisconnectParticipant(roomName, userId string) error {
 	return err
 }
 
-func (s *livekitService) CreateJoinToken(roomName, userId string) (string, error) {
+func (s *livekitService) MuteParticipant(roomName, userId, kind string, muted bool) error {
+	p, err := s.lksClient.GetParticipant(context.Background(), &livekit.RoomParticipantIdentity{
+		Room:     roomName,
+		Identity: userId,
+	})
+	if err != nil {
+		return err
+	}
+
+	trackType := livekit.TrackType_AUDIO
+	if kind == MediaVideo {
+		trackType = livekit.TrackType_VIDEO
+	}
+
+	for _, t := range p.Tracks {
+		if t.Type != trackType || t.Muted == muted {
+			continue
+		}
+		_, err = s.lksClient.MutePublishedTrack(context.Background(), &livekit.MuteRoomTrackRequest{
+			Room:     roomName,
+			Identity: userId,
+			TrackSid: t.Sid,
+			Muted:    muted,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeParticipant forbids the participant to publish and subscribe, till it is removed from the room
+func (s *livekitService) RevokeParticipant(roomName, userId string) error {
+	_, err := s.lksClient.UpdateParticipant(context.Background(), &livekit.UpdateParticipantRequest{
+		Room:       roomName,
+		Identity:   userId,
+		Permission: &livekit.ParticipantPermission{},
+	})
+
+	return err
+}
+
+// joinTokenTTL is short, so a removed participant can't join again with the old token,
+// clients request a new one to reconnect
+const joinTokenTTL = 10 * time.Minute
+
+// CreateJoinToken creates the token for the room, the name is shown to other participants
+func (s *livekitService) CreateJoinToken(roomName, userId, name string) (string, error) {
 	at := auth.NewAccessToken(s.APIKey, s.APISercret)
 	grant := &auth.VideoGrant{
 		RoomJoin: true,
@@ -85,7 +215,8 @@ func (s *livekitService) CreateJoinToken(roomName, userId string) (string, error
 	}
 	at.AddGrant(grant).
 		SetIdentity(userId).
-		SetValidFor(time.Hour)
+		SetName(name).
+		SetValidFor(joinTokenTTL)
 
 	return at.ToJWT()
 }
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"mkozhukh/chat/data"
+	"strings"
+	"time"
+)
+
+const MeetingSignal = "meeting"
+
+// MeetingEvent is sent to chat members, Op is one of remind, start, cancel
+type MeetingEvent struct {
+	Op      string        `json:"op"`
+	Meeting *data.Meeting `json:"meeting"`
+}
+
+type meetingsService struct {
+	dao *data.DAO
+	all *ServiceAll
+
+	RemindBefore time.Duration
+}
+
+func newMeetingsService(dao *data.DAO, all *ServiceAll) *meetingsService {
+	s := &meetingsService{
+		dao:          dao,
+		all:          all,
+		RemindBefore: 5 * time.Minute,
+	}
+
+	return s
+}
+
+// Schedule adds the meeting to the chat, the group call is started at its start time
+func (s *meetingsService) Schedule(userId, chatId int, title string, start time.Time, duration int) (*data.Meeting, error) {
+	if !data.Features.WithGroupCalls {
+		return nil, data.ErrFeatureDisabled
+	}
+	if start.Before(time.Now().Add(-time.Minute)) {
+		return nil, data.ErrWrongValue
+	}
+
+	m := data.Meeting{
+		ChatID:   chatId,
+		UserID:   userId,
+		Title:    strings.TrimSpace(title),
+		Start:    start,
+		Duration: duration,
+	}
+	err := s.dao.Meetings.Add(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Cancel cancels not started meeting, it is allowed for the organizer and admins of the chat
+func (s *meetingsService) Cancel(userId, id int) error {
+	m, err := s.dao.Meetings.GetOne(id)
+	if err != nil {
+		return err
+	}
+	if m.UserID != userId && !s.dao.Chats.IsAdmin(m.ChatID, userId) {
+		return data.ErrAccessDenied
+	}
+	if m.Status != data.MeetingScheduled && m.Status != data.MeetingReminded {
+		return data.ErrWrongValue
+	}
+
+	err = s.dao.Meetings.SetStatus(m, data.MeetingCancelled)
+	if err != nil {
+		return err
+	}
+
+	s.notify(m, "cancel")
+	return nil
+}
+
+// Join starts or joins the call of the meeting, the call can be started before the scheduled time
+func (s *meetingsService) Join(ctx *CallContext, id int) (*data.Call, error) {
+	m, err := s.dao.Meetings.GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+	if m.Status == data.MeetingCancelled || m.Status == data.MeetingMissed || m.End().Before(time.Now()) {
+		return nil, data.ErrWrongValue
+	}
+
+	callService, err := CallProvider.GetService(true)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := callService.Start(ctx, m.ChatID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Status != data.MeetingStarted || m.CallID != call.ID {
+		m.CallID = call.ID
+		err = s.dao.Meetings.SetStatus(m, data.MeetingStarted)
+	}
+
+	return call, err
+}
+
+// Start runs the check of meetings, it must be called after the hub is set, as meetings update their event messages
+func (s *meetingsService) Start() {
+	go s.run()
+}
+
+// run sends reminders and starts meetings, the state is stored in the database, so it survives restarts
+func (s *meetingsService) run() {
+	for range time.Tick(30 * time.Second) {
+		s.check(time.Now())
+	}
+}
+
+func (s *meetingsService) check(now time.Time) {
+	list, err := s.dao.Meetings.GetPending(now.Add(s.RemindBefore))
+	if err != nil {
+		return
+	}
+
+	for i := range list {
+		m := &list[i]
+		switch {
+		case m.End().Before(now):
+			s.dao.Meetings.SetStatus(m, data.MeetingMissed)
+		case !m.Start.After(now):
+			s.start(m)
+		case m.Status == data.MeetingScheduled:
+			err = s.dao.Meetings.SetStatus(m, data.MeetingReminded)
+			if err == nil {
+				s.notify(m, "remind")
+			}
+		}
+	}
+}
+
+// start creates the group call on behalf of the organizer, who is called as other members
+func (s *meetingsService) start(m *data.Meeting) {
+	var call *data.Call
+	err := data.ErrFeatureDisabled
+	if data.Features.WithGroupCalls {
+		call, err = s.all.GroupCalls.StartScheduled(m.ChatID, m.UserID)
+	}
+	if call != nil {
+		m.CallID = call.ID
+	}
+	if err != nil {
+		// members still can start the call from the event
+		log.Printf("[meetings] can't start the call of meeting %d: %s", m.ID, err.Error())
+	}
+
+	err = s.dao.Meetings.SetStatus(m, data.MeetingStarted)
+	if err == nil {
+		s.notify(m, "start")
+	}
+}
+
+func (s *meetingsService) notify(m *data.Meeting, op string) {
+	users := s.dao.UsersCache.GetUsers(m.ChatID)
+	s.all.Informer.SendSignal(MeetingSignal, MeetingEvent{Op: op, Meeting: m}, users, make([]int, len(users)))
+}
+
+// ICalendar returns the meeting as iCalendar event
+func (s *meetingsService) ICalendar(m *data.Meeting, host string) string {
+	const format = "20060102T150405Z"
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//mkozhukh//chat//EN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:meeting-%d@%s", m.ID, icalHost(host)),
+		"DTSTAMP:" + time.Now().UTC().Format(format),
+		"DTSTART:" + m.Start.UTC().Format(format),
+		"DTEND:" + m.End().UTC().Format(format),
+		"SUMMARY:" + icalEscape(m.Title),
+	}
+	if host != "" {
+		lines = append(lines, "URL:"+strings.TrimRight(host, "/"))
+	}
+	if m.Status == data.MeetingCancelled {
+		lines = append(lines, "STATUS:CANCELLED")
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+
+	out := strings.Builder{}
+	for _, l := range lines {
+		out.WriteString(icalFold(l))
+		out.WriteString("\r\n")
+	}
+	return out.String()
+}
+
+func icalHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host = strings.Split(host, "/")[0]
+	if host == "" {
+		return "chat"
+	}
+	return host
+}
+
+func icalEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r", "", "\n", `\n`).Replace(text)
+}
+
+// icalFold splits lines longer than 75 octets, without breaking utf-8 sequences
+func icalFold(line string) string {
+	out := strings.Builder{}
+	size := 0
+	for _, r := range line {
+		l := len(string(r))
+		if size+l > 75 {
+			out.WriteString("\r\n ")
+			size = 1
+		}
+		out.WriteRune(r)
+		size += l
+	}
+	return out.String()
+}
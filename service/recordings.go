@@ -0,0 +1,330 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mkozhukh/chat/data"
+	"os"
+	"sync"
+	"time"
+)
+
+var errAlreadyRecording = errors.New("#ERR_06")
+
+const RecordingSignal = "recording"
+
+// RecordingEvent informs participants that the call is recorded
+type RecordingEvent struct {
+	CallID    int  `json:"call"`
+	UserID    int  `json:"user"`
+	Recording bool `json:"recording"`
+}
+
+type recordingsService struct {
+	dao    *data.DAO
+	all    *ServiceAll
+	server string
+
+	// the egress needs some time to finish the file
+	waitStep time.Duration
+	waitMax  time.Duration
+
+	// recordings, which are being saved
+	mu     sync.Mutex
+	saving map[int]bool
+}
+
+func newRecordingsService(dao *data.DAO, all *ServiceAll) *recordingsService {
+	return &recordingsService{
+		dao:      dao,
+		all:      all,
+		waitStep: 5 * time.Second,
+		waitMax:  30 * time.Minute,
+		saving:   make(map[int]bool),
+	}
+}
+
+// callEnded checks the final status of the call, all of them are above 900
+func callEnded(call *data.Call) bool {
+	return call.Status > 900
+}
+
+// SetServer sets the public url, it is used in links of stored recordings
+func (s *recordingsService) SetServer(public string) {
+	s.server = public
+}
+
+// Start starts the recording of the active call, LiveKit calls are recorded by Egress,
+// clients of other calls record them and upload the file
+func (s *recordingsService) Start(ctx *CallContext, callId int) (*data.Recording, error) {
+	call, err := s.getCall(ctx, callId)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.dao.Recordings.GetActive(call.ID)
+	if err != nil {
+		return nil, err
+	}
+	if active.ID != 0 {
+		return nil, errAlreadyRecording
+	}
+
+	r := data.Recording{CallID: call.ID, UserID: ctx.UserID}
+	if s.all.Livekit != nil && call.RoomName != "" {
+		if !s.all.Livekit.CanRecord() {
+			return nil, data.ErrFeatureDisabled
+		}
+
+		name := fmt.Sprintf("call-%d-%d.mp4", call.ID, time.Now().Unix())
+		r.EgressID, r.Path, err = s.all.Livekit.StartRecording(call.RoomName, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.dao.Recordings.Add(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.all.Informer.SendSignalToParticipants(call, RecordingSignal, RecordingEvent{CallID: call.ID, UserID: ctx.UserID, Recording: true})
+	return &r, nil
+}
+
+func (s *recordingsService) Stop(ctx *CallContext, callId int) error {
+	call, err := s.getCall(ctx, callId)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.dao.Recordings.GetActive(call.ID)
+	if err != nil || r.ID == 0 {
+		return err
+	}
+
+	err = s.stop(&r)
+	if err != nil {
+		return err
+	}
+
+	s.all.Informer.SendSignalToParticipants(call, RecordingSignal, RecordingEvent{CallID: call.ID, UserID: ctx.UserID, Recording: false})
+	return nil
+}
+
+// Upload stores the recording made by the client, it is sent to the chat when the call ends
+func (s *recordingsService) Upload(ctx *CallContext, callId int, file io.ReadSeeker, name, server string) error {
+	call, err := s.dao.Calls.Get(callId)
+	if err != nil {
+		return err
+	}
+
+	recs, err := s.dao.Recordings.GetByCall(call.ID)
+	if err != nil {
+		return err
+	}
+
+	var r *data.Recording
+	for i := len(recs) - 1; i >= 0; i-- {
+		x := &recs[i]
+		if x.UserID == ctx.UserID && x.EgressID == "" && x.AttachmentID == 0 && x.Status <= data.RecordingStopped {
+			r = x
+			break
+		}
+	}
+	if r == nil {
+		return data.ErrAccessDenied
+	}
+
+	if r.Status == data.RecordingActive {
+		err = s.stop(r)
+		if err != nil {
+			return err
+		}
+		if !callEnded(&call) {
+			s.all.Informer.SendSignalToParticipants(&call, RecordingSignal, RecordingEvent{CallID: call.ID, UserID: ctx.UserID, Recording: false})
+		}
+	}
+
+	if callEnded(&call) {
+		return s.post(&call, r, file, name, server)
+	}
+
+	att, err := s.dao.Files.PostDraft(call.ChatID, ctx.UserID, file, name, server)
+	if err != nil {
+		return err
+	}
+	r.AttachmentID = att.ID
+	err = s.dao.Recordings.Save(r)
+	if err != nil {
+		return err
+	}
+
+	// the call could end during the upload
+	call, err = s.dao.Calls.Get(callId)
+	if err == nil && callEnded(&call) {
+		err = s.dao.Recordings.SendDraft(r, call.ChatID)
+	}
+	return err
+}
+
+// Finish stops recordings of the ended call and sends ready ones to the chat
+func (s *recordingsService) Finish(call *data.Call) {
+	recs, err := s.dao.Recordings.GetByCall(call.ID)
+	if err != nil {
+		return
+	}
+
+	for i := range recs {
+		r := &recs[i]
+		if r.Status == data.RecordingActive {
+			if err := s.stop(r); err != nil {
+				// the egress stops itself, when the room is closed, so its status is checked while saving
+				log.Println("[recording] can't stop:", err.Error())
+				s.dao.Recordings.Stop(r)
+			}
+		}
+		if r.Status != data.RecordingStopped {
+			continue
+		}
+
+		s.save(call, r)
+	}
+}
+
+// Resume stops and saves recordings of calls, which have ended while the server was stopped or restarted
+func (s *recordingsService) Resume() error {
+	recs, err := s.dao.Recordings.GetUnsaved()
+	if err != nil {
+		return err
+	}
+
+	done := make(map[int]bool)
+	for _, r := range recs {
+		if done[r.CallID] {
+			continue
+		}
+		done[r.CallID] = true
+
+		call, err := s.dao.Calls.Get(r.CallID)
+		if err != nil {
+			return err
+		}
+		if callEnded(&call) {
+			s.Finish(&call)
+		}
+	}
+	return nil
+}
+
+// save sends the stopped recording of the ended call to the chat
+func (s *recordingsService) save(call *data.Call, r *data.Recording) {
+	if r.EgressID != "" {
+		if s.all.Livekit == nil {
+			log.Printf("[recording] %d can't be saved, livekit is not configured", r.ID)
+			return
+		}
+		if s.startSaving(r.ID) {
+			go s.saveEgress(*call, *r)
+		}
+	} else if r.AttachmentID != 0 {
+		s.dao.Recordings.SendDraft(r, call.ChatID)
+	}
+}
+
+func (s *recordingsService) startSaving(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saving[id] {
+		return false
+	}
+	s.saving[id] = true
+	return true
+}
+
+func (s *recordingsService) doneSaving(id int) {
+	s.mu.Lock()
+	delete(s.saving, id)
+	s.mu.Unlock()
+}
+
+func (s *recordingsService) getCall(ctx *CallContext, callId int) (*data.Call, error) {
+	call, err := s.dao.Calls.Get(callId)
+	if err != nil {
+		return nil, err
+	}
+
+	cu := call.GetByUserID(ctx.UserID)
+	if call.Status != data.CallStatusActive || cu == nil || cu.Status == data.CallUserStatusDisconnected {
+		return nil, data.ErrAccessDenied
+	}
+
+	return &call, nil
+}
+
+func (s *recordingsService) stop(r *data.Recording) error {
+	if r.EgressID != "" {
+		if s.all.Livekit == nil {
+			return data.ErrFeatureDisabled
+		}
+		err := s.all.Livekit.StopRecording(r.EgressID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.dao.Recordings.Stop(r)
+}
+
+// saveEgress waits till the egress completes the file and sends it to the chat
+func (s *recordingsService) saveEgress(call data.Call, r data.Recording) {
+	defer s.doneSaving(r.ID)
+
+	for waited := time.Duration(0); ; waited += s.waitStep {
+		time.Sleep(s.waitStep)
+
+		done, err := s.all.Livekit.RecordingStatus(call.RoomName, r.EgressID)
+		if err != nil || !done && waited >= s.waitMax {
+			if err != nil {
+				log.Println("[recording] egress error:", err.Error())
+			}
+			r.Status = data.RecordingFailed
+			s.dao.Recordings.Save(&r)
+			return
+		}
+		if done {
+			break
+		}
+	}
+
+	f, err := os.Open(r.Path)
+	if err != nil {
+		log.Println("[recording] can't open the file:", err.Error())
+		r.Status = data.RecordingFailed
+		s.dao.Recordings.Save(&r)
+		return
+	}
+	err = s.post(&call, &r, f, fmt.Sprintf("call-%d.mp4", call.ID), s.server)
+	f.Close()
+	if err != nil {
+		// the file is kept for the manual recovery
+		log.Println("[recording] can't store the file:", err.Error())
+		return
+	}
+	os.Remove(r.Path)
+}
+
+func (s *recordingsService) post(call *data.Call, r *data.Recording, file io.ReadSeeker, name, server string) error {
+	err := s.dao.Files.PostFile(call.ChatID, r.UserID, file, name, server)
+	if err != nil {
+		r.Status = data.RecordingFailed
+		s.dao.Recordings.Save(r)
+		return err
+	}
+
+	r.Status = data.RecordingSaved
+	return s.dao.Recordings.Save(r)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"mkozhukh/chat/data"
+	"net"
+	"sync"
+	"time"
+)
+
+type sessionsService struct {
+	dao *data.DAO
+	all *ServiceAll
+
+	mu sync.Mutex
+	// conns holds open socket connections of each device
+	conns map[int]map[*trackedConn]struct{}
+}
+
+type trackedConn struct {
+	net.Conn
+	device  int
+	service *sessionsService
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.service.untrack(c)
+	})
+	return c.Conn.Close()
+}
+
+func newSessionsService(dao *data.DAO, all *ServiceAll) *sessionsService {
+	return &sessionsService{
+		dao:   dao,
+		all:   all,
+		conns: make(map[int]map[*trackedConn]struct{}),
+	}
+}
+
+// Track registers the connection of the device, so it can be closed when the session is revoked
+func (s *sessionsService) Track(device int, conn net.Conn) net.Conn {
+	tc := &trackedConn{Conn: conn, device: device, service: s}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conns[device]
+	if !ok {
+		c = make(map[*trackedConn]struct{})
+		s.conns[device] = c
+	}
+	c[tc] = struct{}{}
+
+	return tc
+}
+
+func (s *sessionsService) untrack(tc *trackedConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.conns[tc.device]
+	if !ok {
+		return
+	}
+	delete(c, tc)
+	if len(c) == 0 {
+		delete(s.conns, tc.device)
+	}
+}
+
+func (s *sessionsService) GetAll(userId, currentDevice int) ([]data.Session, error) {
+	sessions, err := s.dao.Sessions.GetAll(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentDevice
+	}
+	return sessions, nil
+}
+
+// Revoke rejects the token of the session and closes sockets of its device
+func (s *sessionsService) Revoke(userId, device int) error {
+	err := s.dao.Sessions.Revoke(device, userId)
+	if err != nil {
+		return err
+	}
+
+	s.all.Informer.SendSignal("session", "revoked", []int{userId}, []int{device})
+
+	// give some time to deliver the signal
+	time.AfterFunc(time.Second, func() {
+		s.mu.Lock()
+		conns := make([]*trackedConn, 0, len(s.conns[device]))
+		for c := range s.conns[device] {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,473 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mkozhukh/chat/data"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+type SFUConfig struct {
+	Enabled bool
+	// range of UDP ports for media, any free port is used if not set
+	PortMin uint16
+	PortMax uint16
+	// public IP of the server behind NAT
+	PublicIP string
+}
+
+// signals of the built-in SFU, the server sends offers and candidates,
+// clients send join, answers and candidates through CallsAPI.Signal
+const (
+	SFUSignalJoin      = "join"
+	SFUSignalOffer     = "offer"
+	SFUSignalAnswer    = "answer"
+	SFUSignalCandidate = "candidate"
+)
+
+var errUnknownSignal = errors.New("unknown signal")
+
+// sfuService forwards media of group calls between participants,
+// it is used instead of LiveKit when the latter is not configured
+type sfuService struct {
+	api *webrtc.API
+	all *ServiceAll
+
+	mu    sync.Mutex
+	rooms map[string]*sfuRoom
+}
+
+type sfuRoom struct {
+	mu     sync.Mutex
+	peers  map[int]*sfuPeer
+	tracks []*sfuTrack
+}
+
+type sfuPeer struct {
+	UserID   int
+	DeviceID int
+	pc       *webrtc.PeerConnection
+	// the offer is sent when the current negotiation is finished
+	pending bool
+	// set by moderators, media of muted kinds is not forwarded
+	mutedAudio int32
+	mutedVideo int32
+}
+
+type sfuTrack struct {
+	owner int
+	local *webrtc.TrackLocalStaticRTP
+	// the publisher of the track is asked for key frames
+	source *sfuPeer
+	ssrc   uint32
+}
+
+func newSFUService(cfg SFUConfig, all *ServiceAll) *sfuService {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		log.Println("[sfu] can't register codecs:", err.Error())
+		return nil
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		log.Println("[sfu] can't register interceptors:", err.Error())
+		return nil
+	}
+
+	se := webrtc.SettingEngine{}
+	if cfg.PortMin != 0 && cfg.PortMax != 0 {
+		if err := se.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			log.Println("[sfu] wrong range of ports:", err.Error())
+		}
+	}
+	if cfg.PublicIP != "" {
+		se.SetNAT1To1IPs([]string{cfg.PublicIP}, webrtc.ICECandidateTypeHost)
+	}
+
+	s := &sfuService{
+		api:   webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir), webrtc.WithSettingEngine(se)),
+		all:   all,
+		rooms: make(map[string]*sfuRoom),
+	}
+
+	return s
+}
+
+func (s *sfuService) CreateRoom(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rooms[name]; !ok {
+		s.rooms[name] = &sfuRoom{peers: make(map[int]*sfuPeer)}
+	}
+	return name, nil
+}
+
+func (s *sfuService) DeleteRoom(name string) error {
+	s.mu.Lock()
+	r, ok := s.rooms[name]
+	delete(s.rooms, name)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, p := range r.peers {
+		p.pc.Close()
+		delete(r.peers, id)
+	}
+	r.tracks = nil
+
+	return nil
+}
+
+func (s *sfuService) DisconnectParticipant(roomName, userId string) error {
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		return err
+	}
+
+	r := s.getRoom(roomName)
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.peers[id]
+	if ok {
+		r.removePeer(p)
+		s.syncPeers(r)
+	}
+
+	return nil
+}
+
+func (s *sfuService) MuteParticipant(roomName, userId, kind string, muted bool) error {
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		return err
+	}
+
+	r := s.getRoom(roomName)
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.peers[id]
+	if !ok {
+		return nil
+	}
+
+	flag := &p.mutedAudio
+	if kind == MediaVideo {
+		flag = &p.mutedVideo
+	}
+	var v int32
+	if muted {
+		v = 1
+	}
+	atomic.StoreInt32(flag, v)
+
+	return nil
+}
+
+// Signal handles signals of the group call participant
+func (s *sfuService) Signal(ctx *CallContext, call *data.Call, kind, msg string) error {
+	r := s.getRoom(call.RoomName)
+	if r == nil {
+		return data.ErrAccessDenied
+	}
+
+	switch kind {
+	case SFUSignalJoin:
+		return s.join(r, ctx)
+	case SFUSignalAnswer:
+		sdp := webrtc.SessionDescription{}
+		err := json.Unmarshal([]byte(msg), &sdp)
+		if err != nil {
+			return data.ErrWrongValue
+		}
+		return s.answer(r, ctx, sdp)
+	case SFUSignalCandidate:
+		c := webrtc.ICECandidateInit{}
+		err := json.Unmarshal([]byte(msg), &c)
+		if err != nil {
+			return data.ErrWrongValue
+		}
+		return s.candidate(r, ctx, c)
+	}
+
+	return errUnknownSignal
+}
+
+func (s *sfuService) getRoom(name string) *sfuRoom {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[name]
+}
+
+func (s *sfuService) join(r *sfuRoom, ctx *CallContext) error {
+	// the server doesn't need TURN, STUN finds its public address
+	config := webrtc.Configuration{}
+	if stun := s.all.ICE.STUN(); len(stun) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: stun}}
+	}
+
+	pc, err := s.api.NewPeerConnection(config)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		_, err = pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		if err != nil {
+			pc.Close()
+			return err
+		}
+	}
+
+	p := &sfuPeer{UserID: ctx.UserID, DeviceID: ctx.DeviceID, pc: pc}
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			s.all.Informer.SendSignal(SFUSignalCandidate, c.ToJSON(), []int{p.UserID}, []int{p.DeviceID})
+		}
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			pc.Close()
+		case webrtc.PeerConnectionStateClosed:
+			r.mu.Lock()
+			if r.peers[p.UserID] == p {
+				r.removePeer(p)
+				s.syncPeers(r)
+			}
+			r.mu.Unlock()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.forward(r, p, remote)
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if old, ok := r.peers[p.UserID]; ok {
+		// joined from another device
+		r.removePeer(old)
+	}
+	r.peers[p.UserID] = p
+	s.syncPeers(r)
+
+	return nil
+}
+
+func (s *sfuService) answer(r *sfuRoom, ctx *CallContext, sdp webrtc.SessionDescription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.getPeer(ctx)
+	if p == nil {
+		return data.ErrAccessDenied
+	}
+
+	err := p.pc.SetRemoteDescription(sdp)
+	if err != nil {
+		return err
+	}
+
+	if p.pending {
+		p.pending = false
+		return s.offer(p)
+	}
+	return nil
+}
+
+func (s *sfuService) candidate(r *sfuRoom, ctx *CallContext, c webrtc.ICECandidateInit) error {
+	r.mu.Lock()
+	p := r.getPeer(ctx)
+	r.mu.Unlock()
+
+	if p == nil {
+		return data.ErrAccessDenied
+	}
+	return p.pc.AddICECandidate(c)
+}
+
+// forward copies packets of the participant's track to all other participants
+func (s *sfuService) forward(r *sfuRoom, p *sfuPeer, remote *webrtc.TrackRemote) {
+	// stream id allows clients to find the author of the track
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), strconv.Itoa(p.UserID))
+	if err != nil {
+		log.Println("[sfu] can't create track:", err.Error())
+		return
+	}
+	t := &sfuTrack{owner: p.UserID, local: local, source: p, ssrc: uint32(remote.SSRC())}
+
+	muted := &p.mutedAudio
+	if remote.Kind() == webrtc.RTPCodecTypeVideo {
+		muted = &p.mutedVideo
+	}
+
+	r.mu.Lock()
+	r.tracks = append(r.tracks, t)
+	s.syncPeers(r)
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.removeTrack(t)
+		s.syncPeers(r)
+		r.mu.Unlock()
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+		if atomic.LoadInt32(muted) == 1 {
+			continue
+		}
+		if _, err = local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// syncPeers adds tracks of other participants to each peer and removes the stopped ones, should be called under the room's lock
+func (s *sfuService) syncPeers(r *sfuRoom) {
+	for _, p := range r.peers {
+		changed := false
+
+		sent := make(map[webrtc.TrackLocal]bool)
+		for _, sender := range p.pc.GetSenders() {
+			if sender.Track() == nil {
+				continue
+			}
+			if r.hasTrack(sender.Track()) {
+				sent[sender.Track()] = true
+			} else if err := p.pc.RemoveTrack(sender); err == nil {
+				changed = true
+			}
+		}
+
+		for _, t := range r.tracks {
+			if t.owner == p.UserID || sent[t.local] {
+				continue
+			}
+			if sender, err := p.pc.AddTrack(t.local); err == nil {
+				changed = true
+				// the new subscriber can't decode video till the next key frame
+				if t.local.Kind() == webrtc.RTPCodecTypeVideo {
+					t.requestKeyFrame()
+				}
+				go t.readRTCP(sender)
+			}
+		}
+
+		// the first offer contains transceivers for the participant's own media
+		if changed || p.pc.LocalDescription() == nil {
+			if err := s.offer(p); err != nil {
+				log.Println("[sfu] can't create offer:", err.Error())
+			}
+		}
+	}
+}
+
+func (s *sfuService) offer(p *sfuPeer) error {
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		p.pending = true
+		return nil
+	}
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	err = p.pc.SetLocalDescription(offer)
+	if err != nil {
+		return err
+	}
+
+	s.all.Informer.SendSignal(SFUSignalOffer, offer, []int{p.UserID}, []int{p.DeviceID})
+	return nil
+}
+
+func (t *sfuTrack) requestKeyFrame() {
+	t.source.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: t.ssrc}})
+}
+
+// readRTCP passes requests of key frames from the subscriber to the publisher, it ends when the track is removed
+func (t *sfuTrack) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range packets {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				t.requestKeyFrame()
+			}
+		}
+	}
+}
+
+func (r *sfuRoom) getPeer(ctx *CallContext) *sfuPeer {
+	p, ok := r.peers[ctx.UserID]
+	if !ok || p.DeviceID != ctx.DeviceID {
+		return nil
+	}
+	return p
+}
+
+func (r *sfuRoom) removePeer(p *sfuPeer) {
+	delete(r.peers, p.UserID)
+	for i := len(r.tracks) - 1; i >= 0; i-- {
+		if r.tracks[i].owner == p.UserID {
+			r.tracks = append(r.tracks[:i], r.tracks[i+1:]...)
+		}
+	}
+	go p.pc.Close()
+}
+
+func (r *sfuRoom) removeTrack(t *sfuTrack) {
+	for i := range r.tracks {
+		if r.tracks[i] == t {
+			r.tracks = append(r.tracks[:i], r.tracks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *sfuRoom) hasTrack(local webrtc.TrackLocal) bool {
+	for _, t := range r.tracks {
+		if t.local == local {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type localStorage struct {
+	folder string
+}
+
+func NewLocal(folder string) Storage {
+	return &localStorage{folder: folder}
+}
+
+func (s *localStorage) path(key string) string {
+	clean := filepath.Clean("/" + key)
+	return filepath.Join(s.folder, clean)
+}
+
+func (s *localStorage) Save(key string, source io.Reader) (int64, error) {
+	target := s.path(key)
+	err := os.MkdirAll(filepath.Dir(target), 0770)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(f, source)
+}
+
+func (s *localStorage) Open(key string) (io.ReadCloser, *Info, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, &Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (s *localStorage) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) List(prefix string) ([]string, error) {
+	out := make([]string, 0)
+	root := s.path(prefix)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		key, err := filepath.Rel(s.folder, path)
+		if err != nil {
+			return err
+		}
+		out = append(out, strings.ReplaceAll(key, string(filepath.Separator), "/"))
+		return nil
+	})
+
+	return out, err
+}
+
+func (s *localStorage) SignedURL(key, name string, expires time.Duration) (string, error) {
+	return "", nil
+}
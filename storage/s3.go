@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config describes an S3 compatible storage ( AWS, MinIO, etc. )
+type S3Config struct {
+	Endpoint  string
+	Region    string `default:"us-east-1"`
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle enables http://host/bucket/key urls, required by MinIO
+	PathStyle bool
+}
+
+type s3Storage struct {
+	cfg    S3Config
+	base   *url.URL
+	client *http.Client
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+func NewS3(cfg S3Config) (Storage, error) {
+	base, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	return &s3Storage{
+		cfg:    cfg,
+		base:   base,
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (s *s3Storage) objectURL(key string) *url.URL {
+	u := *s.base
+	key = strings.TrimPrefix(key, "/")
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return &u
+}
+
+func (s *s3Storage) Save(key string, source io.Reader) (int64, error) {
+	var size int64 = -1
+	if seeker, ok := source.(io.Seeker); ok {
+		cur, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err == nil {
+				size = end - cur
+			}
+			seeker.Seek(cur, io.SeekStart)
+		}
+	}
+	if size < 0 {
+		// the length of the body must be known before the upload
+		buf, err := ioutil.ReadAll(source)
+		if err != nil {
+			return 0, err
+		}
+		size = int64(len(buf))
+		source = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(key).String(), ioutil.NopCloser(source))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+
+	res, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	return size, nil
+}
+
+func (s *s3Storage) Open(key string) (io.ReadCloser, *Info, error) {
+	req, err := http.NewRequest("GET", s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &Info{Size: res.ContentLength}
+	info.ModTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return res.Body, info, nil
+}
+
+func (s *s3Storage) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Storage) List(prefix string) ([]string, error) {
+	out := make([]string, 0)
+	token := ""
+
+	for {
+		u := s.objectURL("")
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		page := listBucketResult{}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range page.Contents {
+			out = append(out, c.Key)
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *s3Storage) SignedURL(key, name string, expires time.Duration) (string, error) {
+	q := url.Values{}
+	if name != "" {
+		q.Set("response-content-disposition", contentDisposition(name))
+	}
+	return s.presign(key, q, expires, time.Now().UTC()), nil
+}
+
+// contentDisposition keeps the original name of the file, the ascii fallback is for older clients
+func contentDisposition(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`, ascii, uriEncode(name))
+}
+
+// presign creates the url with the query signature, q contains additional signed parameters
+func (s *s3Storage) presign(key string, q url.Values, expires time.Duration, now time.Time) string {
+	u := s.objectURL(key)
+	date := now.Format("20060102")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(q)
+
+	canonical := strings.Join([]string{
+		"GET",
+		canonicalPath(u),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signature := s.sign(now, scope, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+
+	return u.String()
+}
+
+func (s *s3Storage) do(req *http.Request) (*http.Response, error) {
+	s.signRequest(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 error, status %d: %s", res.StatusCode, body)
+	}
+
+	return res, nil
+}
+
+// signRequest adds AWS Signature Version 4 headers to the request
+func (s *s3Storage) signRequest(req *http.Request) {
+	s.signRequestAt(req, time.Now().UTC())
+}
+
+// signRequestAt signs the request for the time, the payload is not signed unless
+// its hash is already set in the X-Amz-Content-Sha256 header
+func (s *s3Storage) signRequestAt(req *http.Request, now time.Time) {
+	date := now.Format("20060102")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = unsignedPayload
+	}
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payload,
+		"x-amz-date":           req.Header.Get("X-Amz-Date"),
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	req.URL.RawQuery = canonicalQuery(req.URL.Query())
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	signature := s.sign(now, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func (s *s3Storage) sign(now time.Time, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalPath(u *url.URL) string {
+	parts := strings.Split(u.Path, "/")
+	for i := range parts {
+		parts[i] = uriEncode(parts[i])
+	}
+	path := strings.Join(parts, "/")
+	if path == "" {
+		path = "/"
+	}
+
+	// keep the request url in sync with the signed one
+	u.RawPath = path
+	return path
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes everything except unreserved characters, as required by the signature
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
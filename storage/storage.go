@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps files by keys like "files/{uid}" or "avatars/{name}"
+type Storage interface {
+	Save(key string, source io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, *Info, error)
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+	// SignedURL returns a temporary public url of the file, which is downloaded with the name,
+	// or an empty string if not supported
+	SignedURL(key, name string, expires time.Duration) (string, error)
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+type Config struct {
+	Type     string `default:"local"`
+	Redirect bool
+	S3       S3Config
+}
+
+// New creates a storage of the configured type, the local one uses the data folder
+func New(cfg Config, dataFolder string) (Storage, error) {
+	return NewByType(cfg.Type, cfg, dataFolder)
+}
+
+func NewByType(kind string, cfg Config, dataFolder string) (Storage, error) {
+	switch kind {
+	case "", "local":
+		return NewLocal(dataFolder), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+
+	return nil, errors.New("unknown storage type: " + kind)
+}
+
+// Copy moves a single file between storages
+func Copy(from, to Storage, key string) error {
+	source, _, err := from.Open(key)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	_, err = to.Save(key, source)
+	return err
+}